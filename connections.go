@@ -0,0 +1,112 @@
+package moxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// connContextKey carries the net.Conn a request arrived on.
+type connContextKey struct{}
+
+// connRequestContextKey carries the ConnectionDetails of a request.
+type connRequestContextKey struct{}
+
+// Connections returns statistics for every connection the server has
+// accepted, in order, including closed ones. Comparing it with the request
+// journal shows how a client pools connections.
+// Example: if n := len(ms.Connections()); n != 1 { t.Errorf("expected one pooled connection, got %d", n) }
+func (m *MockServer) Connections() []ConnectionStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := make([]ConnectionStats, len(m.connHistory))
+	for i, conn := range m.connHistory {
+		stats[i] = *conn
+	}
+	return stats
+}
+
+// Reuses returns how many requests reused the connection after its first.
+func (s ConnectionStats) Reuses() int {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.Requests - 1
+}
+
+// configureConnections applies the keep-alive options of the config to server.
+func (m *MockServer) configureConnections(server *http.Server) {
+	server.ConnState = m.trackConnState
+	server.ConnContext = connContext
+	server.IdleTimeout = m.config.IdleTimeout
+	if m.config.DisableKeepAlive {
+		server.SetKeepAlivesEnabled(false)
+	}
+}
+
+// connContext is used as http.Server.ConnContext to make the connection
+// available to requests.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// trackConnState is used as http.Server.ConnState to keep connection
+// statistics and to forget per-connection TLS state once a connection goes
+// away.
+func (m *MockServer) trackConnState(conn net.Conn, state http.ConnState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state == http.StateNew {
+		if m.conns == nil {
+			m.conns = make(map[net.Conn]*ConnectionStats)
+		}
+		stats := &ConnectionStats{
+			ID:         uint64(len(m.connHistory) + 1),
+			RemoteAddr: conn.RemoteAddr().String(),
+			Opened:     time.Now(),
+		}
+		m.conns[conn] = stats
+		m.connHistory = append(m.connHistory, stats)
+	}
+	if stats, ok := m.conns[conn]; ok {
+		stats.State = state
+	}
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	if stats, ok := m.conns[conn]; ok {
+		stats.Closed = time.Now()
+		delete(m.conns, conn)
+	}
+	delete(m.connCerts, conn.RemoteAddr().String())
+}
+
+// countConnRequest counts r against the connection it arrived on and returns
+// r with its ConnectionDetails. On reaching Config.MaxRequestsPerConn it asks
+// for the connection to be closed after the response.
+func (m *MockServer) countConnRequest(w http.ResponseWriter, r *http.Request) *http.Request {
+	conn, ok := r.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return r
+	}
+	m.mu.Lock()
+	stats, ok := m.conns[conn]
+	if !ok {
+		m.mu.Unlock()
+		return r
+	}
+	stats.Requests++
+	details := &ConnectionDetails{ID: stats.ID, RemoteAddr: stats.RemoteAddr, Request: stats.Requests}
+	m.mu.Unlock()
+	if max := m.config.MaxRequestsPerConn; max > 0 && details.Request >= max && r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "close")
+	}
+	return r.WithContext(context.WithValue(r.Context(), connRequestContextKey{}, details))
+}
+
+// connectionDetails returns the ConnectionDetails recorded for r, if any.
+func connectionDetails(r *http.Request) *ConnectionDetails {
+	details, _ := r.Context().Value(connRequestContextKey{}).(*ConnectionDetails)
+	return details
+}
@@ -0,0 +1,155 @@
+package moxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// waitForConnections polls until check accepts the connection statistics.
+func waitForConnections(t *testing.T, ms *MockServer, check func([]ConnectionStats) bool) []ConnectionStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conns := ms.Connections()
+		if check(conns) || time.Now().After(deadline) {
+			return conns
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnections_TrackReuse(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+
+	pooled := ms.Client()
+	for i := 0; i < 3; i++ {
+		getWith(t, pooled, ms.URL()+"/ping")
+	}
+	conns := ms.Connections()
+	if len(conns) != 1 {
+		t.Fatalf("expected one pooled connection, got %d", len(conns))
+	}
+	if conns[0].ID != 1 || conns[0].Requests != 3 || conns[0].Reuses() != 2 || conns[0].State != http.StateIdle {
+		t.Errorf("unexpected connection stats: %+v", conns[0])
+	}
+	for i, record := range ms.GetRecordedRequests() {
+		if record.Connection == nil || record.Connection.ID != 1 || record.Connection.Request != i+1 {
+			t.Errorf("request %d: unexpected connection details %+v", i, record.Connection)
+		}
+		if record.Connection != nil && record.Connection.RemoteAddr != conns[0].RemoteAddr {
+			t.Errorf("request %d: expected remote address %s, got %s", i, conns[0].RemoteAddr, record.Connection.RemoteAddr)
+		}
+	}
+
+	unpooled := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	getWith(t, unpooled, ms.URL()+"/ping")
+	getWith(t, unpooled, ms.URL()+"/ping")
+	conns = waitForConnections(t, ms, func(conns []ConnectionStats) bool {
+		return len(conns) == 3 && !conns[1].Closed.IsZero() && !conns[2].Closed.IsZero()
+	})
+	if len(conns) != 3 {
+		t.Fatalf("expected a new connection per unpooled request, got %d connections", len(conns))
+	}
+	for _, conn := range conns[1:] {
+		if conn.Requests != 1 || conn.State != http.StateClosed || conn.Closed.IsZero() {
+			t.Errorf("unexpected stats for an unpooled connection: %+v", conn)
+		}
+	}
+}
+
+func TestConnections_DisableKeepAlive(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{DisableKeepAlive: true})
+	defer ms.Close()
+	addPing(ms)
+
+	client := ms.Client()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ms.URL() + "/ping")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		safeClose(t, resp.Body)
+		if !resp.Close {
+			t.Error("expected the server to close the connection")
+		}
+	}
+	if conns := ms.Connections(); len(conns) != 3 {
+		t.Errorf("expected a connection per request, got %d", len(conns))
+	}
+}
+
+func TestConnections_MaxRequestsPerConn(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{MaxRequestsPerConn: 2})
+	defer ms.Close()
+	addPing(ms)
+
+	client := ms.Client()
+	for i := 0; i < 5; i++ {
+		getWith(t, client, ms.URL()+"/ping")
+	}
+	conns := ms.Connections()
+	if len(conns) != 3 {
+		t.Fatalf("expected 3 connections for 5 requests, got %d", len(conns))
+	}
+	for i, want := range []int{2, 2, 1} {
+		if conns[i].Requests != want {
+			t.Errorf("connection %d: expected %d requests, got %d", conns[i].ID, want, conns[i].Requests)
+		}
+	}
+}
+
+func TestConnections_IdleTimeout(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{IdleTimeout: 50 * time.Millisecond})
+	defer ms.Close()
+	addPing(ms)
+
+	client := ms.Client()
+	getWith(t, client, ms.URL()+"/ping")
+	conns := waitForConnections(t, ms, func(conns []ConnectionStats) bool {
+		return len(conns) == 1 && conns[0].State == http.StateClosed
+	})
+	if conns[0].State != http.StateClosed {
+		t.Fatalf("expected the idle connection to be closed, got %s", conns[0].State)
+	}
+	getWith(t, client, ms.URL()+"/ping")
+	if conns := ms.Connections(); len(conns) != 2 {
+		t.Errorf("expected the client to reconnect, got %d connections", len(conns))
+	}
+}
+
+func TestConnections_HTTP2Multiplexing(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{
+		Protocol:           HTTPS,
+		TLSConfig:          &TLSOptions{NextProtos: []string{"h2", "http/1.1"}},
+		MaxRequestsPerConn: 1,
+	})
+	defer ms.Close()
+	addPing(ms)
+
+	client := ms.Client()
+	for i := 0; i < 3; i++ {
+		getWith(t, client, ms.URL()+"/ping")
+	}
+	// Connection: close does not apply to HTTP/2, so the connection is reused.
+	conns := ms.Connections()
+	if len(conns) != 1 || conns[0].Requests != 3 {
+		t.Errorf("expected one HTTP/2 connection serving 3 requests, got %+v", conns)
+	}
+}
+
+func TestConnections_InMemoryRequests(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{InMemory: true})
+	defer ms.Close()
+	addPing(ms)
+
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	if record := ms.GetRecordedRequests()[0]; record.Connection != nil {
+		t.Errorf("expected no connection details for an in-memory request, got %+v", record.Connection)
+	}
+	if conns := ms.Connections(); len(conns) != 0 {
+		t.Errorf("expected no connections, got %d", len(conns))
+	}
+}
@@ -0,0 +1,124 @@
+package moxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// cookieSession records the cookies issued by a MockServer's responses:
+// cookie name -> issued value -> expiry (zero for session cookies).
+type cookieSession map[string]map[string]time.Time
+
+// sessionContextKey carries the server's cookieSession to matchers.
+type sessionContextKey struct{}
+
+// WithCookie adds a matcher requiring the request to send cookie name with the given value.
+// Example: .WithCookie("theme", "dark")
+func (e *Expectation) WithCookie(name, value string) *Expectation {
+	if e.Request.Cookies == nil {
+		e.Request.Cookies = make(map[string]string)
+	}
+	e.Request.Cookies[name] = value
+	return e
+}
+
+// WithSessionCookie adds a matcher requiring the request to send cookie name
+// with a value previously issued by this server through AndSetCookie, that has
+// not expired or been deleted since. It simulates a server-side session, e.g.
+// a login expectation sets the cookie and protected endpoints require it:
+//
+//	ms.AddExpectation(NewExpectation().WithRequestMethod("POST").WithPath("/login").
+//		AndSetCookie(&http.Cookie{Name: "session", Value: "abc"}))
+//	ms.AddExpectation(NewExpectation().WithRequestMethod("GET").WithPath("/profile").
+//		WithSessionCookie("session").AndRespondWithString("ok", 200))
+func (e *Expectation) WithSessionCookie(name string) *Expectation {
+	e.Request.SessionCookies = append(e.Request.SessionCookies, name)
+	return e
+}
+
+// AndSetCookie adds a Set-Cookie header to the current response. It can be
+// called several times to set multiple cookies. Deleting a cookie (MaxAge < 0)
+// also ends the matching server-side session tracked for WithSessionCookie.
+// Example: .AndSetCookie(&http.Cookie{Name: "session", Value: "abc", HttpOnly: true})
+func (e *Expectation) AndSetCookie(cookie *http.Cookie) *Expectation {
+	resp := e.getCurrentResponse()
+	c := *cookie
+	resp.Cookies = append(resp.Cookies, &c)
+	return e
+}
+
+// ClearCookieSession forgets every cookie issued by the server, ending all
+// sessions tracked for WithSessionCookie.
+func (m *MockServer) ClearCookieSession() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cookieSession = make(cookieSession)
+}
+
+// cookieMismatch explains why the request cookies do not match the
+// expectation. It returns an empty string if they match.
+func (req *RequestExpectation) cookieMismatch(r *http.Request) string {
+	for _, name := range sortedKeys(req.Cookies) {
+		expected := req.Cookies[name]
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return fmt.Sprintf("cookie %q: expected %q, not sent", name, expected)
+		}
+		if cookie.Value != expected {
+			return fmt.Sprintf("cookie %q: expected %q, got %q", name, expected, cookie.Value)
+		}
+	}
+	if len(req.SessionCookies) == 0 {
+		return ""
+	}
+	session, _ := r.Context().Value(sessionContextKey{}).(cookieSession)
+	for _, name := range req.SessionCookies {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return fmt.Sprintf("session cookie %q: not sent", name)
+		}
+		if !session.valid(name, cookie.Value, time.Now()) {
+			return fmt.Sprintf("session cookie %q: %q was not issued by the server or has expired", name, cookie.Value)
+		}
+	}
+	return ""
+}
+
+// record tracks cookies set by a response. Cookies that are deleted or already
+// expired end the session for their value.
+func (s cookieSession) record(cookies []*http.Cookie, now time.Time) {
+	for _, c := range cookies {
+		var expires time.Time
+		switch {
+		case c.MaxAge > 0:
+			expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			expires = c.Expires
+		}
+		if c.MaxAge < 0 || (!expires.IsZero() && !expires.After(now)) {
+			if c.Value == "" {
+				delete(s, c.Name)
+			} else {
+				delete(s[c.Name], c.Value)
+			}
+			continue
+		}
+		if s[c.Name] == nil {
+			s[c.Name] = make(map[string]time.Time)
+		}
+		s[c.Name][c.Value] = expires
+	}
+}
+
+// valid reports whether value was issued for cookie name and has not expired.
+func (s cookieSession) valid(name, value string, now time.Time) bool {
+	expires, ok := s[name][value]
+	return ok && (expires.IsZero() || expires.After(now))
+}
+
+// withCookieSession returns r carrying the session for WithSessionCookie matchers.
+func withCookieSession(r *http.Request, session cookieSession) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session))
+}
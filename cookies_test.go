@@ -0,0 +1,194 @@
+package moxy
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithCookie(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		WithCookie("theme", "dark").
+		WithCookie("lang", "en")
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	r.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+	r.AddCookie(&http.Cookie{Name: "other", Value: "x"})
+	if !e.matches(r, nil) {
+		t.Errorf("expected match: %s", e.mismatch(r, nil))
+	}
+
+	wrong, _ := http.NewRequest("GET", "/", nil)
+	wrong.AddCookie(&http.Cookie{Name: "theme", Value: "light"})
+	wrong.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+	if reason := e.mismatch(wrong, nil); reason != `cookie "theme": expected "dark", got "light"` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+
+	missing, _ := http.NewRequest("GET", "/", nil)
+	if reason := e.mismatch(missing, nil); reason != `cookie "lang": expected "en", not sent` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+}
+
+func TestAndSetCookie_MultipleCookies(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200).
+		AndSetCookie(&http.Cookie{Name: "a", Value: "1", Path: "/", HttpOnly: true}).
+		AndSetCookie(&http.Cookie{Name: "b", Value: "2", MaxAge: 60}))
+
+	resp, err := http.Get(ms.URL() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer safeClose(t, resp.Body)
+
+	cookies := resp.Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("expected 2 cookies, got %d: %v", len(resp.Header.Values("Set-Cookie")), resp.Header.Values("Set-Cookie"))
+	}
+	if cookies[0].Name != "a" || cookies[0].Value != "1" || !cookies[0].HttpOnly || cookies[0].Path != "/" {
+		t.Errorf("unexpected first cookie: %+v", cookies[0])
+	}
+	if cookies[1].Name != "b" || cookies[1].Value != "2" || cookies[1].MaxAge != 60 {
+		t.Errorf("unexpected second cookie: %+v", cookies[1])
+	}
+}
+
+func TestWithSessionCookie_LoginFlow(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{LogUnmatched: false})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/login").
+		AndRespondWithString("welcome", 200).
+		AndSetCookie(&http.Cookie{Name: "session", Value: "abc123", Path: "/"}))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/logout").
+		AndRespondWithString("bye", 200).
+		AndSetCookie(&http.Cookie{Name: "session", Path: "/", MaxAge: -1}))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/profile").
+		WithSessionCookie("session").
+		AndRespondWithString("profile", 200))
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	status := func(method, path string) int {
+		req, _ := http.NewRequest(method, ms.URL()+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		safeClose(t, resp.Body)
+		return resp.StatusCode
+	}
+
+	if got := status("GET", "/profile"); got != http.StatusTeapot {
+		t.Errorf("expected profile to be rejected before login, got %d", got)
+	}
+	if got := status("POST", "/login"); got != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d", got)
+	}
+	if got := status("GET", "/profile"); got != http.StatusOK {
+		t.Errorf("expected profile to succeed after login, got %d", got)
+	}
+
+	// A forged cookie value is rejected even though the cookie name matches.
+	forged, _ := http.NewRequest("GET", ms.URL()+"/profile", nil)
+	forged.AddCookie(&http.Cookie{Name: "session", Value: "forged"})
+	resp, err := http.DefaultClient.Do(forged)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected forged session to be rejected, got %d", resp.StatusCode)
+	}
+	unmatched := ms.GetUnmatchedRequests()
+	last := unmatched[len(unmatched)-1].Mismatches
+	if !strings.Contains(last[len(last)-1], `session cookie "session": "forged" was not issued by the server`) {
+		t.Errorf("unexpected mismatches: %v", last)
+	}
+
+	if got := status("POST", "/logout"); got != http.StatusOK {
+		t.Fatalf("expected logout to succeed, got %d", got)
+	}
+	// Replay the old cookie: the server-side session has ended.
+	replay, _ := http.NewRequest("GET", ms.URL()+"/profile", nil)
+	replay.AddCookie(&http.Cookie{Name: "session", Value: "abc123"})
+	resp, err = http.DefaultClient.Do(replay)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected session to end after logout, got %d", resp.StatusCode)
+	}
+}
+
+func TestCookieSession_Expiry(t *testing.T) {
+	now := time.Now()
+	session := make(cookieSession)
+	session.record([]*http.Cookie{
+		{Name: "short", Value: "s", MaxAge: 10},
+		{Name: "dated", Value: "d", Expires: now.Add(time.Hour)},
+		{Name: "plain", Value: "p"},
+		{Name: "stale", Value: "x", Expires: now.Add(-time.Hour)},
+	}, now)
+
+	if !session.valid("short", "s", now.Add(5*time.Second)) || session.valid("short", "s", now.Add(11*time.Second)) {
+		t.Error("unexpected validity for MaxAge cookie")
+	}
+	if !session.valid("dated", "d", now.Add(time.Minute)) || session.valid("dated", "d", now.Add(2*time.Hour)) {
+		t.Error("unexpected validity for Expires cookie")
+	}
+	if !session.valid("plain", "p", now.Add(24*time.Hour)) {
+		t.Error("expected session cookie to stay valid")
+	}
+	if session.valid("stale", "x", now) {
+		t.Error("expected already expired cookie not to be tracked")
+	}
+}
+
+func TestMockServer_ClearCookieSession(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{LogUnmatched: false})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/login").
+		AndSetCookie(&http.Cookie{Name: "session", Value: "abc"}))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/me").
+		WithSessionCookie("session"))
+
+	resp, err := http.Post(ms.URL()+"/login", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	ms.ClearCookieSession()
+
+	req, _ := http.NewRequest("GET", ms.URL()+"/me", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected cleared session to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -3,10 +3,12 @@ package moxy
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -30,15 +32,11 @@ func (e *Expectation) WithRequestMethod(method string) *Expectation {
 }
 
 // WithPath sets a path pattern for the Expectation.
-// It converts curly-brace path variables to regex automatically.
-// Example: .WithPath("/api/{id}/foo/{name}")
+// It converts curly-brace path variables to regex automatically; typed
+// variables such as {id:int} and {id:uuid} only match values of that type.
+// Example: .WithPath("/api/{id:int}/foo/{name}")
 func (e *Expectation) WithPath(pattern string) *Expectation {
-	regexPattern := convertBracesToRegex(pattern)
-	compiled, err := regexp.Compile(regexPattern)
-	if err != nil {
-		panic(fmt.Sprintf("invalid path pattern %q: %v", pattern, err))
-	}
-	e.Request.PathPattern = compiled
+	e.Request.PathPattern = compilePathPattern(pattern, convertBracesToRegex(pattern))
 	return e
 }
 
@@ -64,13 +62,6 @@ func (e *Expectation) WithPathVariables(vars map[string]string) *Expectation {
 	return e
 }
 
-func convertBracesToRegex(pattern string) string {
-	// Replace {var} with (?P<var>[^/]+)
-	re := regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)}`)
-	result := re.ReplaceAllString(pattern, `(?P<$1>[^/]+)`)
-	return "^" + result + "$"
-}
-
 // WithQueryParam adds a query parameter matcher to the Expectation.
 // Example: .WithQueryParam("id", "123")
 func (e *Expectation) WithQueryParam(key, value string) *Expectation {
@@ -93,6 +84,32 @@ func (e *Expectation) WithQueryParams(params map[string]string) *Expectation {
 	return e
 }
 
+// WithQueryParamValues adds a matcher for all values of a repeated query
+// parameter. The request must carry exactly these values; if ordered is false
+// they may appear in any order.
+// Example: .WithQueryParamValues("tag", []string{"a", "b"}, false) matches ?tag=b&tag=a
+func (e *Expectation) WithQueryParamValues(key string, values []string, ordered bool) *Expectation {
+	if e.Request.QueryParamValues == nil {
+		e.Request.QueryParamValues = make(map[string]ValuesExpectation)
+	}
+	e.Request.QueryParamValues[key] = ValuesExpectation{Values: values, Ordered: ordered}
+	return e
+}
+
+// WithoutQueryParam adds a matcher requiring that the query parameter is absent.
+// Example: .WithoutQueryParam("debug")
+func (e *Expectation) WithoutQueryParam(key string) *Expectation {
+	e.Request.AbsentQueryParams = append(e.Request.AbsentQueryParams, key)
+	return e
+}
+
+// WithNoExtraQueryParams rejects requests carrying query parameters other than
+// those expected through WithQueryParam(s) and WithQueryParamValues.
+func (e *Expectation) WithNoExtraQueryParams() *Expectation {
+	e.Request.StrictQueryParams = true
+	return e
+}
+
 // WithHeader adds a header matcher to the Expectation.
 // Keys are normalized to lowercase for case-insensitive matching.
 // Example: .WithHeader("Authorization", "Bearer token")
@@ -117,6 +134,76 @@ func (e *Expectation) WithHeaders(headers map[string]string) *Expectation {
 	return e
 }
 
+// WithHeaderValues adds a matcher for all values of a repeated header, one per
+// header line. The request must carry exactly these values; if ordered is false
+// they may appear in any order. Comma-separated values on one line are not split.
+// Example: .WithHeaderValues("Accept", []string{"application/json", "text/plain"}, true)
+func (e *Expectation) WithHeaderValues(key string, values []string, ordered bool) *Expectation {
+	if e.Request.HeaderValues == nil {
+		e.Request.HeaderValues = make(map[string]ValuesExpectation)
+	}
+	e.Request.HeaderValues[strings.ToLower(key)] = ValuesExpectation{Values: values, Ordered: ordered}
+	return e
+}
+
+// WithHost adds a matcher for the request host, taken from the Host header.
+// Matching is case-insensitive and * matches a single DNS label. If pattern
+// has no port, any port matches.
+// Example: .WithHost("*.tenants.example.com")
+func (e *Expectation) WithHost(pattern string) *Expectation {
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(pattern)), `\*`, `[^.:]+`)
+	if _, _, err := net.SplitHostPort(pattern); err != nil {
+		quoted += `(:\d+)?`
+	}
+	e.Request.HostPattern = regexp.MustCompile("^" + quoted + "$")
+	return e
+}
+
+// WithScheme adds a matcher for the request scheme, "http" or "https".
+// Example: .WithScheme("https")
+func (e *Expectation) WithScheme(scheme string) *Expectation {
+	e.Request.Scheme = strings.ToLower(scheme)
+	return e
+}
+
+// WithRemoteIP adds a matcher requiring the client address to be within cidr.
+// A plain IP address matches only that address. It panics if cidr is invalid.
+// Example: .WithRemoteIP("127.0.0.0/8")
+func (e *Expectation) WithRemoteIP(cidr string) *Expectation {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			panic(fmt.Sprintf("invalid remote IP %q", cidr))
+		}
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		cidr = fmt.Sprintf("%s/%d", cidr, bits)
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(fmt.Sprintf("invalid remote IP %q: %v", cidr, err))
+	}
+	e.Request.RemoteNet = network
+	return e
+}
+
+// WithProto adds a matcher for the request protocol version.
+// Example: .WithProto("HTTP/2.0")
+func (e *Expectation) WithProto(proto string) *Expectation {
+	e.Request.Proto = proto
+	return e
+}
+
+// WithContentLength adds a matcher for the declared request Content-Length,
+// which is -1 if unknown (e.g. chunked bodies).
+// Example: .WithContentLength(LessThanOrEqual(1024))
+func (e *Expectation) WithContentLength(matcher ValueMatcher) *Expectation {
+	e.Request.ContentLength = matcher
+	return e
+}
+
 // WithRequestBody sets the expected raw request body for this Expectation.
 // Example: .WithRequestBody("{\"name\":\"test\"}")
 func (e *Expectation) WithRequestBody(body []byte) *Expectation {
@@ -211,6 +298,15 @@ func (e *Expectation) Once() *Expectation {
 	return e.Times(1)
 }
 
+// WithPriority sets the priority of this expectation. When several
+// expectations match a request, the one with the highest priority is used;
+// ties are broken by Config.MatchMostSpecific, then by registration order.
+// Example: .WithPriority(10)
+func (e *Expectation) WithPriority(priority int) *Expectation {
+	e.Priority = priority
+	return e
+}
+
 // InvocationCounter returns how many times this expectation has been matched.
 func (e *Expectation) InvocationCounter() int {
 	return e.InvocationCount
@@ -297,6 +393,42 @@ func (e *Expectation) WithResponseHeaders(headers map[string]string) *Expectatio
 	return e
 }
 
+// AddResponseHeader adds a value to a response header of the current response
+// without replacing earlier values, producing one header line per value.
+// Example: .AddResponseHeader("Link", `</page/2>; rel="next"`).AddResponseHeader("Link", `</page/9>; rel="last"`)
+func (e *Expectation) AddResponseHeader(key, value string) *Expectation {
+	resp := e.getCurrentResponse()
+	if resp.HeaderValues == nil {
+		resp.HeaderValues = make(http.Header)
+	}
+	resp.HeaderValues.Add(key, value)
+	return e
+}
+
+// WithResponseHeaderValues sets every value of a repeated response header of
+// the current response, replacing values added earlier.
+// Example: .WithResponseHeaderValues("Vary", []string{"Accept", "Accept-Encoding"})
+func (e *Expectation) WithResponseHeaderValues(key string, values []string) *Expectation {
+	resp := e.getCurrentResponse()
+	if resp.HeaderValues == nil {
+		resp.HeaderValues = make(http.Header)
+	}
+	resp.HeaderValues[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	return e
+}
+
+// WithResponseTrailer adds an HTTP trailer to the current response. Trailers
+// are announced in the Trailer header and sent after the body.
+// Example: .WithResponseTrailer("Grpc-Status", "0")
+func (e *Expectation) WithResponseTrailer(key, value string) *Expectation {
+	resp := e.getCurrentResponse()
+	if resp.Trailers == nil {
+		resp.Trailers = make(http.Header)
+	}
+	resp.Trailers.Add(key, value)
+	return e
+}
+
 // WithResponseDelay sets a delay for the current response
 func (e *Expectation) WithResponseDelay(d time.Duration) *Expectation {
 	resp := e.getCurrentResponse()
@@ -306,61 +438,119 @@ func (e *Expectation) WithResponseDelay(d time.Duration) *Expectation {
 
 // matches checks if a request matches this expectation.
 func (e *Expectation) matches(r *http.Request, body []byte) bool {
+	return e.mismatch(r, body) == ""
+}
+
+// mismatch explains why a request does not match this expectation.
+// It returns an empty string if the request matches.
+func (e *Expectation) mismatch(r *http.Request, body []byte) string {
 	// --- HTTP Method Matching ---
 	if r.Method != e.Request.Method {
-		return false
+		return fmt.Sprintf("method: expected %q, got %q", e.Request.Method, r.Method)
+	}
+	// --- Host, Scheme, Protocol and Client Address Matching ---
+	if reason := e.Request.originMismatch(r); reason != "" {
+		return reason
 	}
 
 	// --- Path / PathPattern Matching ---
 	if e.Request.PathPattern != nil {
-		pathMatches := e.Request.PathPattern.FindStringSubmatch(r.URL.Path)
-		if pathMatches == nil {
-			return false
+		if reason := e.Request.pathMismatch(r); reason != "" {
+			return reason
 		}
-		// Capture named groups from regex
-		groupNames := e.Request.PathPattern.SubexpNames()
-		capturedGroups := make(map[string]string, len(groupNames))
-		for groupIndex, groupName := range groupNames {
-			if groupIndex > 0 && groupName != "" {
-				capturedGroups[groupName] = pathMatches[groupIndex]
-			}
+	}
+	// --- Query Parameter Matching ---
+	query := r.URL.Query()
+	for _, paramKey := range sortedKeys(e.Request.QueryParams) {
+		expectedValue := e.Request.QueryParams[paramKey]
+		if actual := query.Get(paramKey); actual != expectedValue {
+			return fmt.Sprintf("query param %q: expected %q, got %q", paramKey, expectedValue, actual)
 		}
-		// Validate that all path variables exactly match expectation
-		for variableKey, expectedValue := range e.Request.PathVariables {
-			actualValue, found := capturedGroups[variableKey]
-			if !found {
-				// Variable not found in the request path
-				return false
-			}
-			if expectedValue != actualValue {
-				// Value mismatch → fail
-				return false
-			}
+	}
+	for _, paramKey := range sortedKeys(e.Request.QueryParamValues) {
+		if reason := e.Request.QueryParamValues[paramKey].mismatch(query[paramKey]); reason != "" {
+			return fmt.Sprintf("query param %q: %s", paramKey, reason)
 		}
 	}
-	// --- Query Parameter Matching ---
-	if len(e.Request.QueryParams) > 0 {
-		query := r.URL.Query()
-		for paramKey, expectedValue := range e.Request.QueryParams {
-			if query.Get(paramKey) != expectedValue {
-				return false
+	for _, paramKey := range e.Request.AbsentQueryParams {
+		if _, present := query[paramKey]; present {
+			return fmt.Sprintf("query param %q: expected to be absent, got %q", paramKey, query[paramKey])
+		}
+	}
+	if e.Request.StrictQueryParams {
+		for _, paramKey := range sortedKeys(query) {
+			_, single := e.Request.QueryParams[paramKey]
+			_, multi := e.Request.QueryParamValues[paramKey]
+			if !single && !multi {
+				return fmt.Sprintf("query param %q: not expected, got %q", paramKey, query[paramKey])
 			}
 		}
 	}
 	// --- Header Matching ---
-	for headerKey, expectedValue := range e.Request.Headers {
+	for _, headerKey := range sortedKeys(e.Request.Headers) {
+		expectedValue := e.Request.Headers[headerKey]
 		actualHeaderValue := r.Header.Get(headerKey)
 		if actualHeaderValue != expectedValue {
-			return false
+			return fmt.Sprintf("header %q: expected %q, got %q", headerKey, expectedValue, actualHeaderValue)
+		}
+	}
+	for _, headerKey := range sortedKeys(e.Request.HeaderValues) {
+		if reason := e.Request.HeaderValues[headerKey].mismatch(r.Header.Values(headerKey)); reason != "" {
+			return fmt.Sprintf("header %q: %s", headerKey, reason)
+		}
+	}
+	// --- Cookie Matching ---
+	if reason := e.Request.cookieMismatch(r); reason != "" {
+		return reason
+	}
+	// --- SOAP Action Matching ---
+	if e.Request.SOAPAction != "" {
+		if actual := soapAction(r); actual != e.Request.SOAPAction {
+			return fmt.Sprintf("soap action: expected %q, got %q", e.Request.SOAPAction, actual)
+		}
+	}
+	// --- Form Matching ---
+	if e.Request.hasFormMatchers() {
+		if reason := e.Request.formMismatch(parseForm(r, body)); reason != "" {
+			return reason
 		}
 	}
 	// --- Body Matching ---
+	if e.Request.ContentLength != nil && !e.Request.ContentLength.Match(float64(r.ContentLength)) {
+		return fmt.Sprintf("content length: expected %s, got %d", e.Request.ContentLength, r.ContentLength)
+	}
 	if e.Request.BodyMatcher != nil {
-		return e.Request.BodyMatcher(body)
+		if !e.Request.BodyMatcher(body) {
+			return "body: rejected by body matcher"
+		}
 	} else if len(e.Request.Body) > 0 && !reflect.DeepEqual(body, e.Request.Body) {
-		return false
+		return fmt.Sprintf("body: expected %d bytes %q, got %d bytes", len(e.Request.Body), truncate(e.Request.Body, 64), len(body))
 	}
-	return true
+	// --- JSONPath Matching ---
+	if len(e.Request.JSONPaths) > 0 {
+		if reason := jsonPathMismatch(e.Request.JSONPaths, body); reason != "" {
+			return reason
+		}
+	}
+	// --- JSON Schema Matching ---
+	if e.Request.JSONSchema != nil {
+		if err := e.Request.JSONSchema.Validate(body); err != nil {
+			return "json schema: " + err.Error()
+		}
+	}
+	// --- GraphQL Matching ---
+	if e.Request.GraphQL != nil {
+		if reason := graphQLMismatch(e.Request.GraphQL, r, body); reason != "" {
+			return reason
+		}
+	}
+	// --- XPath Matching ---
+	if len(e.Request.XPaths) > 0 {
+		if reason := xpathMismatch(e.Request.XPaths, body); reason != "" {
+			return reason
+		}
+	}
+	return ""
 }
 
 // String returns a string representation of the expectation for debugging.
@@ -400,6 +590,99 @@ func containsAll(actual, expected map[string]interface{}) bool {
 	return true
 }
 
+// originMismatch explains why the request host, scheme, protocol or client
+// address does not match. It returns an empty string if they match.
+func (req *RequestExpectation) originMismatch(r *http.Request) string {
+	if req.HostPattern != nil && !req.HostPattern.MatchString(strings.ToLower(r.Host)) {
+		return fmt.Sprintf("host: %q does not match %s", r.Host, req.HostPattern)
+	}
+	if req.Scheme != "" {
+		if actual := requestScheme(r); actual != req.Scheme {
+			return fmt.Sprintf("scheme: expected %q, got %q", req.Scheme, actual)
+		}
+	}
+	if req.Proto != "" && r.Proto != req.Proto {
+		return fmt.Sprintf("proto: expected %q, got %q", req.Proto, r.Proto)
+	}
+	if req.RemoteNet != nil {
+		ip := net.ParseIP(hostWithoutPort(r.RemoteAddr))
+		if ip == nil || !req.RemoteNet.Contains(ip) {
+			return fmt.Sprintf("remote address: %q is not in %s", r.RemoteAddr, req.RemoteNet)
+		}
+	}
+	return ""
+}
+
+// requestScheme returns the scheme the request was received with.
+func requestScheme(r *http.Request) string {
+	if r.URL != nil && r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// specificity ranks how specific the expectation is, for Config.MatchMostSpecific.
+// pathRank is 2 for an exact path, 1 for a pattern and 0 for no path;
+// constraints counts every other matcher.
+func (req *RequestExpectation) specificity() (pathRank, constraints int) {
+	if req.PathPattern != nil {
+		pathRank = 1
+		if _, complete := req.PathPattern.LiteralPrefix(); complete {
+			pathRank = 2
+		}
+	}
+	if req.Method != "" {
+		constraints++
+	}
+	constraints += len(req.PathVariables) + len(req.QueryParams) + len(req.QueryParamValues) +
+		len(req.AbsentQueryParams) + len(req.Headers) + len(req.HeaderValues) +
+		len(req.Cookies) + len(req.SessionCookies) + len(req.FormFields) +
+		len(req.MultipartFields) + len(req.MultipartFiles) + len(req.JSONPaths) + len(req.XPaths)
+	if req.StrictQueryParams {
+		constraints++
+	}
+	if len(req.Body) > 0 || req.BodyMatcher != nil {
+		constraints++
+	}
+	if req.JSONSchema != nil {
+		constraints++
+	}
+	if req.SOAPAction != "" {
+		constraints++
+	}
+	for _, set := range []bool{req.HostPattern != nil, req.Scheme != "", req.RemoteNet != nil, req.Proto != "", req.ContentLength != nil} {
+		if set {
+			constraints++
+		}
+	}
+	if req.GraphQL != nil {
+		if req.GraphQL.OperationName != "" {
+			constraints++
+		}
+		constraints += len(req.GraphQL.QueryContains) + len(req.GraphQL.Variables)
+	}
+	return pathRank, constraints
+}
+
+// mismatch compares actual values against the expectation and explains the
+// difference. It returns an empty string if they match.
+func (v ValuesExpectation) mismatch(actual []string) string {
+	expected, got := v.Values, actual
+	if !v.Ordered {
+		expected = append([]string(nil), expected...)
+		got = append([]string(nil), got...)
+		sort.Strings(expected)
+		sort.Strings(got)
+	}
+	if !reflect.DeepEqual(expected, got) && (len(expected) > 0 || len(got) > 0) {
+		return fmt.Sprintf("expected values %q, got %q", v.Values, actual)
+	}
+	return ""
+}
+
 // AssertCalled checks if the expectation was called exactly `expected` times.
 func (e *Expectation) AssertCalled(expected int) error {
 	if e.InvocationCount != expected {
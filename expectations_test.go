@@ -2,6 +2,7 @@ package moxy
 
 import (
 	"bytes"
+	"crypto/tls"
 	"io"
 	"net/http"
 	"net/url"
@@ -498,3 +499,239 @@ func TestContainsAll(t *testing.T) {
 		}
 	}
 }
+
+func TestWithQueryParamValues(t *testing.T) {
+	cases := []struct {
+		name    string
+		ordered bool
+		query   string
+		match   bool
+	}{
+		{"unordered same order", false, "tag=a&tag=b", true},
+		{"unordered reversed", false, "tag=b&tag=a", true},
+		{"ordered reversed", true, "tag=b&tag=a", false},
+		{"ordered same order", true, "tag=a&tag=b", true},
+		{"missing value", false, "tag=a", false},
+		{"extra value", false, "tag=a&tag=b&tag=c", false},
+		{"duplicate counted", false, "tag=a&tag=a", false},
+		{"absent", false, "", false},
+	}
+	for _, tc := range cases {
+		e := NewExpectation().
+			WithRequestMethod("GET").
+			WithPath("/items").
+			WithQueryParamValues("tag", []string{"a", "b"}, tc.ordered)
+		r, _ := http.NewRequest("GET", "/items?"+tc.query, nil)
+		if got := e.matches(r, nil); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v (%s)", tc.name, tc.match, got, e.mismatch(r, nil))
+		}
+	}
+
+	e := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/items").
+		WithQueryParamValues("tag", []string{"a", "b"}, true)
+	r, _ := http.NewRequest("GET", "/items?tag=b&tag=a", nil)
+	if reason := e.mismatch(r, nil); reason != `query param "tag": expected values ["a" "b"], got ["b" "a"]` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+}
+
+func TestWithoutQueryParamAndNoExtraQueryParams(t *testing.T) {
+	absent := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/items").
+		WithoutQueryParam("debug")
+	strict := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/items").
+		WithQueryParam("page", "1").
+		WithQueryParamValues("tag", []string{"a"}, false).
+		WithNoExtraQueryParams()
+
+	cases := []struct {
+		exp   *Expectation
+		query string
+		match bool
+	}{
+		{absent, "page=1", true},
+		{absent, "debug=", false},
+		{absent, "debug=true", false},
+		{strict, "page=1&tag=a", true},
+		{strict, "page=1&tag=a&sort=asc", false},
+	}
+	for _, tc := range cases {
+		r, _ := http.NewRequest("GET", "/items?"+tc.query, nil)
+		if got := tc.exp.matches(r, nil); got != tc.match {
+			t.Errorf("%s ?%s: expected match=%v, got %v", tc.exp, tc.query, tc.match, got)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/items?page=1&tag=a&sort=asc", nil)
+	if reason := strict.mismatch(r, nil); reason != `query param "sort": not expected, got ["asc"]` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+}
+
+func TestWithHeaderValues(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		WithHeaderValues("Accept", []string{"application/json", "text/plain"}, true)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("accept", "application/json")
+	r.Header.Add("ACCEPT", "text/plain")
+	if !e.matches(r, nil) {
+		t.Errorf("expected match: %s", e.mismatch(r, nil))
+	}
+
+	reversed, _ := http.NewRequest("GET", "/", nil)
+	reversed.Header.Add("Accept", "text/plain")
+	reversed.Header.Add("Accept", "application/json")
+	if e.matches(reversed, nil) {
+		t.Error("expected ordered header values not to match in reverse order")
+	}
+	if !e.WithHeaderValues("Accept", []string{"application/json", "text/plain"}, false).matches(reversed, nil) {
+		t.Error("expected unordered header values to match in reverse order")
+	}
+
+	single, _ := http.NewRequest("GET", "/", nil)
+	single.Header.Set("Accept", "application/json, text/plain")
+	if reason := e.mismatch(single, nil); !strings.HasPrefix(reason, `header "accept": expected values`) {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+}
+
+func TestRequestExpectation_Specificity(t *testing.T) {
+	cases := []struct {
+		name            string
+		exp             *Expectation
+		pathRank, count int
+	}{
+		{"no path", NewExpectation(), 0, 0},
+		{"pattern", NewExpectation().WithRequestMethod("GET").WithPath("/users/{id}"), 1, 1},
+		{"pattern with variable", NewExpectation().WithPath("/users/{id}").WithPathVariable("id", "1"), 1, 1},
+		{"exact", NewExpectation().WithPath("/users/admin"), 2, 0},
+		{"constraints", NewExpectation().
+			WithRequestMethod("POST").
+			WithPath("/users").
+			WithHeader("A", "1").
+			WithQueryParam("q", "x").
+			WithRequestJSONBody(`{}`).
+			WithJSONPath("$.id", Exists()), 2, 5},
+	}
+	for _, tc := range cases {
+		pathRank, count := tc.exp.Request.specificity()
+		if pathRank != tc.pathRank || count != tc.count {
+			t.Errorf("%s: expected (%d, %d), got (%d, %d)", tc.name, tc.pathRank, tc.count, pathRank, count)
+		}
+	}
+}
+
+func TestOriginMatchers(t *testing.T) {
+	newRequest := func(host, remoteAddr, proto string, secure bool) *http.Request {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Host = host
+		r.RemoteAddr = remoteAddr
+		r.Proto = proto
+		if secure {
+			r.TLS = &tls.ConnectionState{}
+		}
+		return r
+	}
+	plain := newRequest("acme.tenants.example.com:8080", "10.1.2.3:51000", "HTTP/1.1", false)
+	secure := newRequest("API.example.com", "[2001:db8::7]:443", "HTTP/2.0", true)
+
+	cases := []struct {
+		name  string
+		exp   *Expectation
+		r     *http.Request
+		match bool
+	}{
+		{"host wildcard any port", NewExpectation().WithHost("*.tenants.example.com"), plain, true},
+		{"host wildcard single label", NewExpectation().WithHost("*.example.com"), plain, false},
+		{"host case-insensitive", NewExpectation().WithHost("api.EXAMPLE.com"), secure, true},
+		{"host with port", NewExpectation().WithHost("acme.tenants.example.com:8080"), plain, true},
+		{"host wrong port", NewExpectation().WithHost("acme.tenants.example.com:9090"), plain, false},
+		{"scheme http", NewExpectation().WithScheme("http"), plain, true},
+		{"scheme https", NewExpectation().WithScheme("HTTPS"), secure, true},
+		{"scheme mismatch", NewExpectation().WithScheme("https"), plain, false},
+		{"remote cidr", NewExpectation().WithRemoteIP("10.0.0.0/8"), plain, true},
+		{"remote cidr mismatch", NewExpectation().WithRemoteIP("192.168.0.0/16"), plain, false},
+		{"remote single ip", NewExpectation().WithRemoteIP("10.1.2.3"), plain, true},
+		{"remote ipv6", NewExpectation().WithRemoteIP("2001:db8::/32"), secure, true},
+		{"proto", NewExpectation().WithProto("HTTP/2.0"), secure, true},
+		{"proto mismatch", NewExpectation().WithProto("HTTP/2.0"), plain, false},
+	}
+	for _, tc := range cases {
+		e := tc.exp.WithRequestMethod("GET")
+		if got := e.matches(tc.r, nil); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v (%s)", tc.name, tc.match, got, e.mismatch(tc.r, nil))
+		}
+	}
+
+	reason := NewExpectation().WithRequestMethod("GET").WithRemoteIP("192.168.0.0/16").mismatch(plain, nil)
+	if reason != `remote address: "10.1.2.3:51000" is not in 192.168.0.0/16` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+}
+
+// TestOriginMatchers_HTTP2Server checks the scheme, protocol and remote IP
+// matchers against requests served over a real HTTP/2 connection.
+func TestOriginMatchers_HTTP2Server(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{
+		Protocol:  HTTPS,
+		TLSConfig: &TLSOptions{NextProtos: []string{"h2", "http/1.1"}},
+	})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		WithScheme("https").
+		WithProto("HTTP/2.0").
+		WithRemoteIP("127.0.0.1").
+		AndRespondWithString("ok", 200))
+
+	resp, err := ms.Client().Get(ms.URL() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected matched HTTP/2 response, got %s %d", resp.Proto, resp.StatusCode)
+	}
+}
+
+func TestWithRemoteIP_InvalidPanics(t *testing.T) {
+	for _, cidr := range []string{"localhost", "10.0.0.0/33", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for %q", cidr)
+				}
+			}()
+			NewExpectation().WithRemoteIP(cidr)
+		}()
+	}
+}
+
+func TestWithContentLength(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithContentLength(LessThanOrEqual(5))
+
+	small, _ := http.NewRequest("POST", "/", strings.NewReader("abc"))
+	if !e.matches(small, []byte("abc")) {
+		t.Errorf("expected small body to match: %s", e.mismatch(small, []byte("abc")))
+	}
+	large, _ := http.NewRequest("POST", "/", strings.NewReader("abcdefgh"))
+	if reason := e.mismatch(large, []byte("abcdefgh")); reason != "content length: expected <= 5, got 8" {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+	chunked, _ := http.NewRequest("POST", "/", io.NopCloser(strings.NewReader("abc")))
+	chunked.ContentLength = -1 // as received by a server for a chunked body
+	if !NewExpectation().WithRequestMethod("POST").WithContentLength(Equals(-1)).matches(chunked, nil) {
+		t.Error("expected unknown content length to be -1")
+	}
+}
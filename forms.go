@@ -0,0 +1,171 @@
+package moxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// parsedForm holds the fields and files decoded from a form body.
+type parsedForm struct {
+	Values    url.Values
+	Files     []UploadedFile
+	Multipart bool
+}
+
+// WithFormField adds a form field matcher to the Expectation.
+// It matches fields of application/x-www-form-urlencoded bodies and
+// non-file parts of multipart/form-data bodies.
+// Example: .WithFormField("username", "alice")
+func (e *Expectation) WithFormField(key, value string) *Expectation {
+	if e.Request.FormFields == nil {
+		e.Request.FormFields = make(map[string]string)
+	}
+	e.Request.FormFields[key] = value
+	return e
+}
+
+// WithFormFields adds multiple form field matchers at once.
+// Example: .WithFormFields(map[string]string{"username": "alice", "remember": "on"})
+func (e *Expectation) WithFormFields(fields map[string]string) *Expectation {
+	if e.Request.FormFields == nil {
+		e.Request.FormFields = make(map[string]string)
+	}
+	for k, v := range fields {
+		e.Request.FormFields[k] = v
+	}
+	return e
+}
+
+// WithMultipartField adds a matcher for a non-file part of a multipart/form-data body.
+// Example: .WithMultipartField("description", "avatar")
+func (e *Expectation) WithMultipartField(key, value string) *Expectation {
+	if e.Request.MultipartFields == nil {
+		e.Request.MultipartFields = make(map[string]string)
+	}
+	e.Request.MultipartFields[key] = value
+	return e
+}
+
+// WithMultipartFile adds a matcher for a file part of a multipart/form-data body.
+// An empty filename matches any filename and a nil contentMatcher matches any content.
+// Example: .WithMultipartFile("avatar", "me.png", func(b []byte) bool { return len(b) > 0 })
+func (e *Expectation) WithMultipartFile(field, filename string, contentMatcher func([]byte) bool) *Expectation {
+	e.Request.MultipartFiles = append(e.Request.MultipartFiles, MultipartFileExpectation{
+		Field:          field,
+		Filename:       filename,
+		ContentMatcher: contentMatcher,
+	})
+	return e
+}
+
+// hasFormMatchers reports whether the expectation needs the body parsed as a form.
+func (r *RequestExpectation) hasFormMatchers() bool {
+	return len(r.FormFields) > 0 || len(r.MultipartFields) > 0 || len(r.MultipartFiles) > 0
+}
+
+// formMismatch checks the form matchers of the expectation against a parsed
+// form and explains the first mismatch. It returns an empty string on match.
+func (r *RequestExpectation) formMismatch(form *parsedForm) string {
+	if form == nil {
+		return "form: body is not an urlencoded or multipart form"
+	}
+	for _, key := range sortedKeys(r.FormFields) {
+		if !containsString(form.Values[key], r.FormFields[key]) {
+			return fmt.Sprintf("form field %q: expected %q, got %q", key, r.FormFields[key], form.Values[key])
+		}
+	}
+	if (len(r.MultipartFields) > 0 || len(r.MultipartFiles) > 0) && !form.Multipart {
+		return "form: expected a multipart/form-data body"
+	}
+	for _, key := range sortedKeys(r.MultipartFields) {
+		if !containsString(form.Values[key], r.MultipartFields[key]) {
+			return fmt.Sprintf("multipart field %q: expected %q, got %q", key, r.MultipartFields[key], form.Values[key])
+		}
+	}
+	for _, expected := range r.MultipartFiles {
+		if !expected.matchesAny(form.Files) {
+			return fmt.Sprintf("multipart file %q: no part matches filename %q and content matcher", expected.Field, expected.Filename)
+		}
+	}
+	return ""
+}
+
+// matchesAny reports whether any uploaded file satisfies the expectation.
+func (f MultipartFileExpectation) matchesAny(files []UploadedFile) bool {
+	for _, file := range files {
+		if file.Field != f.Field {
+			continue
+		}
+		if f.Filename != "" && file.Filename != f.Filename {
+			continue
+		}
+		if f.ContentMatcher != nil && !f.ContentMatcher(file.Content) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// parseForm decodes an application/x-www-form-urlencoded or multipart/form-data
+// body. It returns nil if the request does not carry a form body.
+func parseForm(r *http.Request, body []byte) *parsedForm {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil
+		}
+		return &parsedForm{Values: values}
+	case "multipart/form-data":
+		form, err := parseMultipart(body, params["boundary"])
+		if err != nil {
+			return nil
+		}
+		return form
+	}
+	return nil
+}
+
+// parseMultipart reads all parts of a multipart body, separating files from fields.
+func parseMultipart(body []byte, boundary string) (*parsedForm, error) {
+	if boundary == "" {
+		return nil, errors.New("missing multipart boundary")
+	}
+	form := &parsedForm{Values: url.Values{}, Multipart: true}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(part)
+		_ = part.Close()
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			form.Files = append(form.Files, UploadedFile{
+				Field:       part.FormName(),
+				Filename:    part.FileName(),
+				ContentType: part.Header.Get("Content-Type"),
+				Content:     content,
+			})
+			continue
+		}
+		form.Values.Add(part.FormName(), string(content))
+	}
+}
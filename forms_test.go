@@ -0,0 +1,182 @@
+package moxy
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newMultipartBody builds a multipart/form-data body with one field and one file.
+func newMultipartBody(t *testing.T, fields map[string]string, fileField, filename string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			t.Fatalf("failed to write field: %v", err)
+		}
+	}
+	if fileField != "" {
+		part, err := writer.CreateFormFile(fileField, filename)
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		if _, err := part.Write(content); err != nil {
+			t.Fatalf("failed to write file content: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+	return &buf, writer.FormDataContentType()
+}
+
+func TestWithFormFields(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/login").
+		WithFormField("username", "alice").
+		WithFormFields(map[string]string{"remember": "on"})
+
+	form := url.Values{"username": {"alice"}, "password": {"secret"}, "remember": {"on"}}
+	r, _ := http.NewRequest("POST", "/login", nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if !e.matches(r, []byte(form.Encode())) {
+		t.Error("expected urlencoded form to match")
+	}
+
+	form.Set("username", "bob")
+	if e.matches(r, []byte(form.Encode())) {
+		t.Error("expected form with different username not to match")
+	}
+
+	r.Header.Set("Content-Type", "text/plain")
+	if e.matches(r, []byte("username=alice&remember=on")) {
+		t.Error("expected non-form content type not to match")
+	}
+}
+
+func TestWithFormField_MultipartBody(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/upload").
+		WithFormField("title", "holiday")
+
+	body, contentType := newMultipartBody(t, map[string]string{"title": "holiday"}, "", "", nil)
+	r, _ := http.NewRequest("POST", "/upload", nil)
+	r.Header.Set("Content-Type", contentType)
+	if !e.matches(r, body.Bytes()) {
+		t.Error("expected form field to match a multipart field")
+	}
+}
+
+func TestWithMultipartFile(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/upload").
+		WithMultipartField("title", "avatar").
+		WithMultipartFile("file", "me.png", func(content []byte) bool {
+			return bytes.HasPrefix(content, []byte("PNG"))
+		})
+
+	cases := []struct {
+		name     string
+		filename string
+		content  string
+		match    bool
+	}{
+		{"matching file", "me.png", "PNG-data", true},
+		{"wrong filename", "you.png", "PNG-data", false},
+		{"wrong content", "me.png", "GIF-data", false},
+	}
+	for _, tc := range cases {
+		body, contentType := newMultipartBody(t, map[string]string{"title": "avatar"}, "file", tc.filename, []byte(tc.content))
+		r, _ := http.NewRequest("POST", "/upload", nil)
+		r.Header.Set("Content-Type", contentType)
+		if got := e.matches(r, body.Bytes()); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v", tc.name, tc.match, got)
+		}
+	}
+
+	// Multipart matchers never match urlencoded bodies.
+	r, _ := http.NewRequest("POST", "/upload", nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if e.matches(r, []byte("title=avatar")) {
+		t.Error("expected multipart matchers not to match an urlencoded body")
+	}
+}
+
+func TestWithMultipartFile_AnyFilenameAndContent(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/upload").
+		WithMultipartFile("file", "", nil)
+
+	body, contentType := newMultipartBody(t, nil, "file", "anything.bin", []byte{0x00, 0x01})
+	r, _ := http.NewRequest("POST", "/upload", nil)
+	r.Header.Set("Content-Type", contentType)
+	if !e.matches(r, body.Bytes()) {
+		t.Error("expected any file in field 'file' to match")
+	}
+
+	body, contentType = newMultipartBody(t, nil, "other", "anything.bin", []byte{0x00})
+	r.Header.Set("Content-Type", contentType)
+	if e.matches(r, body.Bytes()) {
+		t.Error("expected file in a different field not to match")
+	}
+}
+
+// TestMockServer_FormUploadJournal verifies uploads are matched end to end and
+// recorded fields and files are available from the journal.
+func TestMockServer_FormUploadJournal(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/upload").
+		WithMultipartFile("report", "q3.csv", nil).
+		AndRespondWithString("stored", 201))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/login").
+		WithFormField("username", "alice").
+		AndRespondWithString("welcome", 200))
+
+	body, contentType := newMultipartBody(t, map[string]string{"quarter": "3"}, "report", "q3.csv", []byte("a,b\n1,2\n"))
+	resp, err := http.Post(ms.URL()+"/upload", contentType, body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != 201 {
+		t.Errorf("expected 201, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.PostForm(ms.URL()+"/login", url.Values{"username": {"alice"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != 200 {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	requests := ms.GetRecordedRequests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 recorded requests, got %d", len(requests))
+	}
+	upload := requests[0]
+	if len(upload.Files) != 1 || upload.Files[0].Filename != "q3.csv" ||
+		!strings.Contains(string(upload.Files[0].Content), "1,2") {
+		t.Errorf("unexpected recorded files: %+v", upload.Files)
+	}
+	if upload.Form["quarter"][0] != "3" {
+		t.Errorf("unexpected recorded multipart fields: %v", upload.Form)
+	}
+	if requests[1].Form["username"][0] != "alice" || requests[1].Files != nil {
+		t.Errorf("unexpected recorded urlencoded form: %+v", requests[1])
+	}
+}
@@ -0,0 +1,290 @@
+package moxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// tunnelContextKey carries the ProxyDetails of a CONNECT tunnel to the
+// requests served inside it.
+type tunnelContextKey struct{}
+
+// ProxyURL returns the URL clients should use as their HTTP proxy, including
+// the credentials of ForwardProxyOptions if set. CONNECT tunnels are only
+// served when Config.ForwardProxy is set.
+// Example: &http.Transport{Proxy: http.ProxyURL(ms.ProxyURL())}
+func (m *MockServer) ProxyURL() *url.URL {
+	u, _ := url.Parse(m.URL())
+	if opts := m.config.ForwardProxy; opts != nil && opts.Username != "" {
+		u.User = url.UserPassword(opts.Username, opts.Password)
+	}
+	return u
+}
+
+// ProxyClient returns a client like Client that sends every request through
+// the mock server as a forward proxy.
+func (m *MockServer) ProxyClient() *http.Client {
+	client := m.Client()
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.Proxy = http.ProxyURL(m.ProxyURL())
+	}
+	return client
+}
+
+// handleProxyRequest applies forward proxy mode to r: it checks proxy
+// credentials and serves CONNECT tunnels. It reports whether the request has
+// been handled and must not be matched.
+func (m *MockServer) handleProxyRequest(w http.ResponseWriter, r *http.Request) bool {
+	if m.config.ForwardProxy == nil || r.Context().Value(tunnelContextKey{}) != nil {
+		return false
+	}
+	if r.Method != http.MethodConnect && !r.URL.IsAbs() {
+		return false // a direct request, not sent to a proxy
+	}
+	if !m.proxyAuthorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="moxy"`)
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return true
+	}
+	if r.Method == http.MethodConnect {
+		m.serveConnect(w, r)
+		return true
+	}
+	return false
+}
+
+// proxyAuthorized checks the Proxy-Authorization credentials of r.
+func (m *MockServer) proxyAuthorized(r *http.Request) bool {
+	opts := m.config.ForwardProxy
+	if opts.Username == "" && opts.Password == "" {
+		return true
+	}
+	// Reuse Request.BasicAuth to parse the Proxy-Authorization header.
+	probe := &http.Request{Header: http.Header{"Authorization": r.Header.Values("Proxy-Authorization")}}
+	username, password, ok := probe.BasicAuth()
+	return ok && username == opts.Username && password == opts.Password
+}
+
+// proxyDetails describes how r reached the server as a forward proxy, or
+// returns nil for direct requests.
+func proxyDetails(r *http.Request) *ProxyDetails {
+	if details, ok := r.Context().Value(tunnelContextKey{}).(*ProxyDetails); ok {
+		return details
+	}
+	if r.URL.IsAbs() {
+		return &ProxyDetails{Target: r.URL.Host, Authorization: r.Header.Get("Proxy-Authorization")}
+	}
+	return nil
+}
+
+// serveConnect establishes a CONNECT tunnel and serves the tunneled
+// connection with the mock server's handler, terminating TLS if the client
+// starts a handshake.
+func (m *MockServer) serveConnect(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "CONNECT is not supported on this connection", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n"); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	tunnel := &tunnelConn{
+		Conn:   conn,
+		reader: rw.Reader,
+		details: &ProxyDetails{
+			Tunneled:      true,
+			Target:        r.Host,
+			Authorization: r.Header.Get("Proxy-Authorization"),
+		},
+	}
+	// A TLS handshake starts with a handshake record (0x16).
+	first, err := tunnel.reader.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	var served net.Conn = tunnel
+	if first[0] == 0x16 {
+		cfg := &tls.Config{GetConfigForClient: m.configForClient}
+		if m.proxyCA != nil {
+			cfg.GetConfigForClient = m.interceptConfigForClient(hostWithoutPort(r.Host))
+		}
+		served = tls.Server(tunnel, cfg)
+	}
+	m.tunnels.deliver(served)
+}
+
+// CACertificate returns the CA that signs intercepted certificates when
+// ForwardProxyOptions.InterceptTLS is set, or nil otherwise. Clients outside
+// Go can be configured to trust it after PEM-encoding it.
+// Example: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ms.CACertificate().Raw})
+func (m *MockServer) CACertificate() *x509.Certificate {
+	if m.proxyCA == nil {
+		return nil
+	}
+	return certificateLeaf(*m.proxyCA)
+}
+
+// interceptionCA returns the CA configured in opts, generating one if unset.
+func interceptionCA(opts *ForwardProxyOptions) *tls.Certificate {
+	if opts.CA != nil {
+		return opts.CA
+	}
+	ca, err := generateCA("moxy interception CA")
+	if err != nil {
+		panic("failed to generate interception CA: " + err.Error())
+	}
+	return &ca
+}
+
+// interceptConfigForClient returns a GetConfigForClient callback for a
+// tunnel to target that serves certificates minted by interceptCertificate.
+func (m *MockServer) interceptConfigForClient(target string) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg, err := m.configForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.interceptCertificate(hello, target)
+		}
+		return cfg, nil
+	}
+}
+
+// interceptCertificate returns the certificate for the SNI server name of
+// hello, or for the tunnel target without SNI, minting and caching it on
+// first use.
+func (m *MockServer) interceptCertificate(hello *tls.ClientHelloInfo, target string) (*tls.Certificate, error) {
+	host := hostWithoutPort(hello.ServerName)
+	if host == "" {
+		host = target
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cert, ok := m.mintedCerts[host]
+	if !ok {
+		minted, err := generateSignedCert(host, *m.proxyCA)
+		if err != nil {
+			return nil, fmt.Errorf("minting certificate for %s: %w", host, err)
+		}
+		if m.mintedCerts == nil {
+			m.mintedCerts = make(map[string]*tls.Certificate)
+		}
+		cert = &minted
+		m.mintedCerts[host] = cert
+	}
+	m.rememberConnCert(hello, *cert)
+	return cert, nil
+}
+
+// startTunnelServer starts the server for connections inside CONNECT tunnels.
+// It shares the handler, including middleware added with Use.
+func (m *MockServer) startTunnelServer() {
+	m.tunnels = &tunnelListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	m.tunnelServer = &http.Server{Handler: http.HandlerFunc(m.serveHTTP)}
+	m.configureConnections(m.tunnelServer)
+	m.tunnelServer.ConnState = m.trackTunnelConnState
+	m.tunnelServer.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		ctx = connContext(ctx, c)
+		if tlsConn, ok := c.(*tls.Conn); ok {
+			c = tlsConn.NetConn()
+		}
+		if tunnel, ok := c.(*tunnelConn); ok {
+			ctx = context.WithValue(ctx, tunnelContextKey{}, tunnel.details)
+		}
+		return ctx
+	}
+	go func() { _ = m.tunnelServer.Serve(m.tunnels) }()
+}
+
+// trackTunnelConnState is used as the tunnel server's http.Server.ConnState.
+// Besides the connection statistics, it keeps the open tunneled connections
+// so that CloseClientConnections can close them.
+func (m *MockServer) trackTunnelConnState(conn net.Conn, state http.ConnState) {
+	m.trackConnState(conn, state)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		if m.tunnelConns == nil {
+			m.tunnelConns = make(map[net.Conn]struct{})
+		}
+		m.tunnelConns[conn] = struct{}{}
+	case http.StateClosed, http.StateHijacked:
+		delete(m.tunnelConns, conn)
+	}
+}
+
+// closeTunnels closes every open tunneled connection.
+func (m *MockServer) closeTunnels() {
+	m.mu.Lock()
+	conns := make([]net.Conn, 0, len(m.tunnelConns))
+	for conn := range m.tunnelConns {
+		conns = append(conns, conn)
+	}
+	m.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// tunnelConn is a hijacked CONNECT connection. Reads go through the buffered
+// reader left by the hijack so that no client bytes are lost.
+type tunnelConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	details *ProxyDetails
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) { return c.reader.Read(p) }
+
+// tunnelListener is a net.Listener fed with tunneled connections.
+type tunnelListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// deliver hands conn to the tunnel server, closing it if the server is closed.
+func (l *tunnelListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *tunnelListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *tunnelListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *tunnelListener) Addr() net.Addr { return tunnelAddr{} }
+
+// tunnelAddr is the placeholder address of the tunnel listener.
+type tunnelAddr struct{}
+
+func (tunnelAddr) Network() string { return "tunnel" }
+func (tunnelAddr) String() string  { return "tunnel" }
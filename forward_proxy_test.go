@@ -0,0 +1,306 @@
+package moxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestForwardProxy_AbsoluteFormRequests(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{}})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithHost("api.example.com").
+		WithPath("/users").
+		AndRespondWithString("proxied users", 200))
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(ms.ProxyURL())}}
+	resp, err := client.Get("http://api.example.com/users")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "proxied users" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+
+	record := ms.GetRecordedRequests()[0]
+	if record.Proxy == nil || record.Proxy.Tunneled || record.Proxy.Target != "api.example.com" {
+		t.Errorf("unexpected proxy details: %+v", record.Proxy)
+	}
+	if record.Host != "api.example.com" || record.URL != "/users" {
+		t.Errorf("unexpected journal entry: %+v", record)
+	}
+}
+
+func TestForwardProxy_ConnectTunnelWithTLS(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{}})
+	defer ms.Close()
+	ms.AddVirtualHost("api.partner.com", nil).AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithScheme("https").
+		WithPath("/status").
+		AndRespondWithString("partner ok", 200))
+
+	// The client verifies the tunneled TLS connection like a real one.
+	resp, err := ms.ProxyClient().Get("https://api.partner.com/status")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "partner ok" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+
+	record := ms.GetRecordedRequests()[0]
+	if record.Proxy == nil || !record.Proxy.Tunneled || record.Proxy.Target != "api.partner.com:443" {
+		t.Errorf("unexpected proxy details: %+v", record.Proxy)
+	}
+	if record.TLS == nil || record.TLS.ServerName != "api.partner.com" ||
+		record.TLS.ServerCertificate.Subject.CommonName != "api.partner.com" {
+		t.Errorf("unexpected TLS details: %+v", record.TLS)
+	}
+}
+
+func TestForwardProxy_ConnectTunnelWithPlainHTTP(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{}})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithHost("legacy.internal").
+		WithPath("/ping").
+		AndRespondWithString("pong", 200))
+
+	conn, err := net.Dial("tcp", ms.server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+
+	_, _ = fmt.Fprint(conn, "CONNECT legacy.internal:80 HTTP/1.1\r\nHost: legacy.internal:80\r\n\r\n")
+	connectResp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil || connectResp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected CONNECT response: %v %v", connectResp, err)
+	}
+
+	_, _ = fmt.Fprint(conn, "GET /ping HTTP/1.1\r\nHost: legacy.internal\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "pong" {
+		t.Errorf("unexpected tunneled response: %d %q", resp.StatusCode, body)
+	}
+	if record := ms.GetRecordedRequests()[0]; record.Proxy == nil || !record.Proxy.Tunneled || record.TLS != nil {
+		t.Errorf("unexpected journal entry: %+v", record)
+	}
+}
+
+func TestForwardProxy_ProxyAuthorization(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{
+		ForwardProxy: &ForwardProxyOptions{Username: "alice", Password: "s3cret"},
+	})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200))
+	ms.AddVirtualHost("secure.example.com", nil).AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("secure ok", 200))
+
+	anonymous, _ := url.Parse(ms.URL())
+	wrong, _ := url.Parse(ms.URL())
+	wrong.User = url.UserPassword("alice", "wrong")
+	cases := []struct {
+		name   string
+		proxy  *url.URL
+		target string
+		status int
+	}{
+		{"no credentials", anonymous, "http://example.com/", http.StatusProxyAuthRequired},
+		{"wrong credentials", wrong, "http://example.com/", http.StatusProxyAuthRequired},
+		{"valid credentials", ms.ProxyURL(), "http://example.com/", http.StatusOK},
+	}
+	for _, tc := range cases {
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(tc.proxy)}}
+		resp, err := client.Get(tc.target)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		safeClose(t, resp.Body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+		if tc.status == http.StatusProxyAuthRequired && resp.Header.Get("Proxy-Authenticate") != `Basic realm="moxy"` {
+			t.Errorf("%s: expected Proxy-Authenticate challenge, got %v", tc.name, resp.Header)
+		}
+	}
+
+	// CONNECT tunnels are refused without credentials.
+	unauthenticated := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(anonymous),
+		TLSClientConfig: &tls.Config{RootCAs: ms.TrustedRootCAs()},
+	}}
+	if _, err := unauthenticated.Get("https://secure.example.com/"); err == nil {
+		t.Error("expected CONNECT without credentials to fail")
+	}
+	resp, err := ms.ProxyClient().Get("https://secure.example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected authenticated CONNECT to succeed, got %d", resp.StatusCode)
+	}
+
+	journal := ms.GetRecordedRequests()
+	if len(journal) != 2 {
+		t.Fatalf("expected only authorized requests to be journaled, got %d", len(journal))
+	}
+	for _, record := range journal {
+		if record.Proxy == nil || record.Proxy.Authorization == "" {
+			t.Errorf("expected Proxy-Authorization to be journaled: %+v", record.Proxy)
+		}
+	}
+}
+
+func TestForwardProxy_DirectRequestsStillWork(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{Username: "u", Password: "p"}})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/direct").
+		AndRespondWithString("direct", 200))
+
+	resp, err := http.Get(ms.URL() + "/direct")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected direct request to bypass proxy authentication, got %d", resp.StatusCode)
+	}
+	if record := ms.GetRecordedRequests()[0]; record.Proxy != nil {
+		t.Errorf("expected no proxy details for a direct request, got %+v", record.Proxy)
+	}
+}
+
+func TestForwardProxy_InterceptTLS(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{InterceptTLS: true}})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithHost("api.stripe.com").
+		WithScheme("https").
+		WithPath("/v1/charges").
+		AndRespondWithString(`{"id":"ch_1"}`, 200))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithHost("hooks.slack.com").
+		WithPath("/services").
+		AndRespondWithString("ok", 200))
+
+	// The client trusts only the interception CA.
+	roots := x509.NewCertPool()
+	roots.AddCert(ms.CACertificate())
+	newClient := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			Proxy:             http.ProxyURL(ms.ProxyURL()),
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			DisableKeepAlives: true,
+		}}
+	}
+
+	requests := []struct{ method, url string }{
+		{"POST", "https://api.stripe.com/v1/charges"},
+		{"GET", "https://hooks.slack.com/services"},
+		{"POST", "https://api.stripe.com/v1/charges"},
+	}
+	for _, req := range requests {
+		httpReq, _ := http.NewRequest(req.method, req.url, nil)
+		resp, err := newClient().Do(httpReq)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", req.method, req.url, err)
+		}
+		safeClose(t, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s %s: expected 200, got %d", req.method, req.url, resp.StatusCode)
+		}
+	}
+
+	journal := ms.GetRecordedRequests()
+	if len(journal) != 3 {
+		t.Fatalf("expected 3 recorded requests, got %d", len(journal))
+	}
+	stripe, slack, stripeAgain := journal[0].TLS.ServerCertificate, journal[1].TLS.ServerCertificate, journal[2].TLS.ServerCertificate
+	if stripe.Subject.CommonName != "api.stripe.com" || slack.Subject.CommonName != "hooks.slack.com" {
+		t.Errorf("expected per-host certificates, got %q and %q", stripe.Subject.CommonName, slack.Subject.CommonName)
+	}
+	if stripe.SerialNumber.Cmp(stripeAgain.SerialNumber) != 0 {
+		t.Error("expected the certificate for a host to be reused")
+	}
+	if err := stripe.CheckSignatureFrom(ms.CACertificate()); err != nil {
+		t.Errorf("expected certificate signed by the CA: %v", err)
+	}
+
+	// TrustedRootCAs, and therefore ProxyClient, include the CA.
+	resp, err := ms.ProxyClient().Get("https://hooks.slack.com/services")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+}
+
+func TestForwardProxy_InterceptTLSWithCustomCA(t *testing.T) {
+	ca, err := generateCA("custom CA")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{InterceptTLS: true, CA: &ca}})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200))
+
+	if !ms.CACertificate().Equal(ca.Leaf) {
+		t.Fatal("expected CACertificate to return the configured CA")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(ms.ProxyURL()),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	// An IP address target is sent without SNI and gets an IP certificate.
+	resp, err := client.Get("https://10.1.2.3:8443/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if cert := ms.GetRecordedRequests()[0].TLS.ServerCertificate; len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.1.2.3" {
+		t.Errorf("expected a certificate for 10.1.2.3, got %v", cert.IPAddresses)
+	}
+}
+
+func TestForwardProxy_CACertificateWithoutInterception(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{}})
+	defer ms.Close()
+	if ms.CACertificate() != nil {
+		t.Error("expected no CA without InterceptTLS")
+	}
+}
@@ -0,0 +1,247 @@
+package moxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// graphQLRequest is the standard GraphQL-over-HTTP request envelope.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLResponse is the standard GraphQL response envelope. Data is only
+// written when it has been set, so request errors can omit it.
+type graphQLResponse struct {
+	Errors []GraphQLError  `json:"errors,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// WithGraphQLOperation adds a matcher for the GraphQL operation name. The name
+// is taken from the operationName field of the envelope or, if that is empty,
+// from the first operation defined at the top level of the query document
+// (empty for an anonymous operation).
+// Example: .WithGraphQLOperation("GetUser")
+func (e *Expectation) WithGraphQLOperation(name string) *Expectation {
+	e.graphQL().OperationName = name
+	return e
+}
+
+// WithGraphQLQueryContaining adds a matcher requiring the query document to
+// contain substring. Multiple calls must all match.
+// Example: .WithGraphQLQueryContaining("user(id: $id)")
+func (e *Expectation) WithGraphQLQueryContaining(substring string) *Expectation {
+	gql := e.graphQL()
+	gql.QueryContains = append(gql.QueryContains, substring)
+	return e
+}
+
+// WithGraphQLVariables adds a partial matcher for the GraphQL variables: the
+// request must contain every variable in partialJSON, extra variables are
+// allowed. It panics if partialJSON is not a JSON object.
+// Example: .WithGraphQLVariables(`{"id":"42"}`)
+func (e *Expectation) WithGraphQLVariables(partialJSON string) *Expectation {
+	var variables map[string]interface{}
+	if err := json.Unmarshal([]byte(partialJSON), &variables); err != nil {
+		panic(fmt.Errorf("invalid expected GraphQL variables: %w", err))
+	}
+	gql := e.graphQL()
+	if gql.Variables == nil {
+		gql.Variables = make(map[string]interface{})
+	}
+	for k, v := range variables {
+		gql.Variables[k] = v
+	}
+	return e
+}
+
+// graphQL returns the GraphQL expectation, creating it if needed.
+func (e *Expectation) graphQL() *GraphQLExpectation {
+	if e.Request.GraphQL == nil {
+		e.Request.GraphQL = &GraphQLExpectation{}
+	}
+	return e.Request.GraphQL
+}
+
+// AndRespondWithGraphQLData sets the current response to a GraphQL response
+// whose data entry is data marshaled as JSON. A json.RawMessage is written as
+// is. It can be combined with AndRespondWithGraphQLErrors for partial results.
+// It panics if data cannot be marshaled.
+// Example: .AndRespondWithGraphQLData(map[string]interface{}{"user": map[string]string{"id": "42"}})
+func (e *Expectation) AndRespondWithGraphQLData(data interface{}) *Expectation {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		panic(fmt.Errorf("invalid GraphQL data: %w", err))
+	}
+	gql := e.graphQLResponse()
+	gql.Data = raw
+	return e.writeGraphQLResponse(gql)
+}
+
+// AndRespondWithGraphQLErrors sets the errors entry of the current GraphQL
+// response. Without AndRespondWithGraphQLData the response has no data entry,
+// as for a request error.
+// Example: .AndRespondWithGraphQLErrors(GraphQLError{Message: "not found", Path: []interface{}{"user"}})
+func (e *Expectation) AndRespondWithGraphQLErrors(errs ...GraphQLError) *Expectation {
+	gql := e.graphQLResponse()
+	gql.Errors = append(gql.Errors, errs...)
+	return e.writeGraphQLResponse(gql)
+}
+
+// graphQLResponse returns the GraphQL envelope of the current response, creating it if needed.
+func (e *Expectation) graphQLResponse() *graphQLResponse {
+	resp := e.getCurrentResponse()
+	if resp.graphQL == nil {
+		resp.graphQL = &graphQLResponse{}
+	}
+	return resp.graphQL
+}
+
+// writeGraphQLResponse renders the envelope into the current response.
+func (e *Expectation) writeGraphQLResponse(gql *graphQLResponse) *Expectation {
+	body, err := json.Marshal(gql)
+	if err != nil {
+		panic(fmt.Errorf("invalid GraphQL response: %w", err))
+	}
+	return e.AndRespondWith(body, http.StatusOK).
+		WithResponseHeader("Content-Type", "application/json")
+}
+
+// parseGraphQLRequest decodes the GraphQL envelope from a JSON POST body or,
+// for GET requests, from the query, operationName and variables parameters.
+func parseGraphQLRequest(r *http.Request, body []byte) (*graphQLRequest, error) {
+	req := &graphQLRequest{}
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return nil, fmt.Errorf("invalid variables: %w", err)
+			}
+		}
+	} else if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+	if req.Query == "" {
+		return nil, fmt.Errorf("missing query")
+	}
+	return req, nil
+}
+
+// operationName returns the explicit operation name or the first one in the query.
+func (g *graphQLRequest) operationName() string {
+	if g.OperationName != "" {
+		return g.OperationName
+	}
+	return firstOperationName(g.Query)
+}
+
+// firstOperationName returns the name of the first operation definition in a
+// query document, or "" if it is anonymous. Only top-level definitions count:
+// string literals, block strings, comments and selection sets are skipped,
+// and fragment definitions are passed over.
+func firstOperationName(document string) string {
+	depth := 0
+	inFragment := false
+	afterKeyword := false
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case strings.HasPrefix(document[i:], `"""`):
+			i += 3
+			for i < len(document) && !strings.HasPrefix(document[i:], `"""`) {
+				if strings.HasPrefix(document[i:], `\"""`) {
+					i += 3 // an escaped \""" does not end the block string
+				}
+				i++
+			}
+			i += 3
+			continue
+		case c == '"':
+			i++
+			for i < len(document) && document[i] != '"' && document[i] != '\n' {
+				if document[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			continue
+		case c == '#':
+			for i < len(document) && document[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '{':
+			if depth == 0 && !inFragment {
+				return "" // the selection set of an anonymous operation
+			}
+			depth++
+			inFragment = false
+		case c == '}':
+			depth--
+		case depth == 0 && isNameStart(c):
+			start := i
+			for i < len(document) && isNameChar(document[i]) {
+				i++
+			}
+			name := document[start:i]
+			switch {
+			case afterKeyword:
+				return name
+			case inFragment:
+			case name == "query" || name == "mutation" || name == "subscription":
+				afterKeyword = true
+			case name == "fragment":
+				inFragment = true
+			}
+			continue
+		case depth == 0 && afterKeyword && !isIgnored(c):
+			return "" // an operation without a name, e.g. query ($id: ID)
+		}
+		i++
+	}
+	return ""
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// isIgnored reports whether c is an ignored token between GraphQL tokens.
+func isIgnored(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ','
+}
+
+// graphQLMismatch explains why a request does not match the GraphQL
+// expectation. It returns an empty string if it matches.
+func graphQLMismatch(expected *GraphQLExpectation, r *http.Request, body []byte) string {
+	req, err := parseGraphQLRequest(r, body)
+	if err != nil {
+		return fmt.Sprintf("graphql: not a GraphQL request: %v", err)
+	}
+	if expected.OperationName != "" {
+		if actual := req.operationName(); actual != expected.OperationName {
+			return fmt.Sprintf("graphql operation: expected %q, got %q", expected.OperationName, actual)
+		}
+	}
+	for _, substring := range expected.QueryContains {
+		if !strings.Contains(req.Query, substring) {
+			return fmt.Sprintf("graphql query: does not contain %q", substring)
+		}
+	}
+	if len(expected.Variables) > 0 && !containsAll(req.Variables, expected.Variables) {
+		return fmt.Sprintf("graphql variables: expected to contain %s, got %s",
+			formatJSONValue(expected.Variables), formatJSONValue(req.Variables))
+	}
+	return ""
+}
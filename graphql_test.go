@@ -0,0 +1,172 @@
+package moxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const getUserQuery = `{
+	"query": "query GetUser($id: ID!) { user(id: $id) { id name } }",
+	"variables": {"id": "42", "filter": {"active": true, "role": "admin"}}
+}`
+
+func TestGraphQL_Matchers(t *testing.T) {
+	cases := []struct {
+		name  string
+		exp   *Expectation
+		body  string
+		match bool
+	}{
+		{"operation from query", NewExpectation().WithGraphQLOperation("GetUser"), getUserQuery, true},
+		{"operation mismatch", NewExpectation().WithGraphQLOperation("ListUsers"), getUserQuery, false},
+		{"explicit operationName", NewExpectation().WithGraphQLOperation("B"),
+			`{"query":"query A { a } query B { b }","operationName":"B"}`, true},
+		{"mutation", NewExpectation().WithGraphQLOperation("CreateUser"),
+			`{"query":"mutation CreateUser { createUser { id } }"}`, true},
+		{"operation name in string literal", NewExpectation().WithGraphQLOperation("Foo"),
+			`{"query":"{ search(text: \"mutation Foo\") { id } }"}`, false},
+		{"keyword in selection set", NewExpectation().WithGraphQLOperation("Plan"),
+			`{"query":"{ user { subscription Plan } }"}`, false},
+		{"query containing", NewExpectation().WithGraphQLQueryContaining("user(id: $id)").WithGraphQLQueryContaining("name"), getUserQuery, true},
+		{"query not containing", NewExpectation().WithGraphQLQueryContaining("email"), getUserQuery, false},
+		{"variables partial", NewExpectation().WithGraphQLVariables(`{"id":"42"}`), getUserQuery, true},
+		{"variables nested partial", NewExpectation().WithGraphQLVariables(`{"filter":{"role":"admin"}}`), getUserQuery, true},
+		{"variables mismatch", NewExpectation().WithGraphQLVariables(`{"id":"7"}`), getUserQuery, false},
+		{"variables missing", NewExpectation().WithGraphQLVariables(`{"id":"42"}`), `{"query":"{ me { id } }"}`, false},
+		{"not graphql", NewExpectation().WithGraphQLOperation("GetUser"), `{"id":"42"}`, false},
+		{"not json", NewExpectation().WithGraphQLOperation("GetUser"), `query GetUser { user }`, false},
+	}
+	r, _ := http.NewRequest("POST", "/graphql", nil)
+	for _, tc := range cases {
+		e := tc.exp.WithRequestMethod("POST").WithPath("/graphql")
+		if got := e.matches(r, []byte(tc.body)); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v (%s)", tc.name, tc.match, got, e.mismatch(r, []byte(tc.body)))
+		}
+	}
+}
+
+func TestFirstOperationName(t *testing.T) {
+	for document, want := range map[string]string{
+		`query GetUser($id: ID!) { user(id: $id) { id } }`:                 "GetUser",
+		`  mutation   CreateUser{ createUser { id } }`:                     "CreateUser",
+		`{ search(text: "mutation Foo") { id } }`:                          "",
+		`{ search(text: """query "Foo" \""" mutation Bar""") { id } }`:     "",
+		`{ user { subscription Plan } }`:                                   "",
+		`query ($id: ID) { user(id: $id) { id } }`:                         "",
+		"# query Commented\nquery Real { a }":                              "Real",
+		`fragment F on User { query Inner } query Outer { user { ...F } }`: "Outer",
+		`query A { a(note: "{") } query B { b }`:                           "A",
+	} {
+		if got := firstOperationName(document); got != want {
+			t.Errorf("%s: expected %q, got %q", document, want, got)
+		}
+	}
+}
+
+func TestGraphQL_GETRequest(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/graphql").
+		WithGraphQLOperation("GetUser").
+		WithGraphQLVariables(`{"id":"42"}`)
+
+	query := url.Values{}
+	query.Set("query", "query GetUser($id: ID!) { user(id: $id) { id } }")
+	query.Set("variables", `{"id":"42"}`)
+	r, _ := http.NewRequest("GET", "/graphql?"+query.Encode(), nil)
+	if !e.matches(r, nil) {
+		t.Errorf("expected GET request to match: %s", e.mismatch(r, nil))
+	}
+}
+
+func TestGraphQL_MismatchReasons(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/graphql", nil)
+	cases := []struct {
+		exp    *Expectation
+		body   string
+		reason string
+	}{
+		{NewExpectation().WithGraphQLOperation("ListUsers"), getUserQuery, `graphql operation: expected "ListUsers", got "GetUser"`},
+		{NewExpectation().WithGraphQLQueryContaining("email"), getUserQuery, `graphql query: does not contain "email"`},
+		{NewExpectation().WithGraphQLVariables(`{"id":"7"}`), getUserQuery, `graphql variables: expected to contain {"id":"7"}, got`},
+		{NewExpectation().WithGraphQLOperation("GetUser"), `{}`, `graphql: not a GraphQL request: missing query`},
+	}
+	for _, tc := range cases {
+		e := tc.exp.WithRequestMethod("POST").WithPath("/graphql")
+		if reason := e.mismatch(r, []byte(tc.body)); !strings.HasPrefix(reason, tc.reason) {
+			t.Errorf("expected reason starting with %q, got %q", tc.reason, reason)
+		}
+	}
+}
+
+func TestGraphQL_InvalidVariablesPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for invalid variables JSON")
+		}
+	}()
+	NewExpectation().WithGraphQLVariables(`["id"]`)
+}
+
+func TestGraphQL_ResponseEnvelopes(t *testing.T) {
+	cases := []struct {
+		name string
+		exp  *Expectation
+		want string
+	}{
+		{"data", NewExpectation().AndRespondWithGraphQLData(map[string]interface{}{"user": map[string]string{"id": "42"}}),
+			`{"data":{"user":{"id":"42"}}}`},
+		{"raw data", NewExpectation().AndRespondWithGraphQLData(json.RawMessage(`{"ok":true}`)),
+			`{"data":{"ok":true}}`},
+		{"errors", NewExpectation().AndRespondWithGraphQLErrors(GraphQLError{Message: "syntax error", Locations: []GraphQLLocation{{Line: 1, Column: 3}}}),
+			`{"errors":[{"message":"syntax error","locations":[{"line":1,"column":3}]}]}`},
+		{"partial result", NewExpectation().
+			AndRespondWithGraphQLData(map[string]interface{}{"user": nil}).
+			AndRespondWithGraphQLErrors(GraphQLError{Message: "not found", Path: []interface{}{"user"}, Extensions: map[string]interface{}{"code": "NOT_FOUND"}}),
+			`{"errors":[{"message":"not found","path":["user"],"extensions":{"code":"NOT_FOUND"}}],"data":{"user":null}}`},
+		{"null data", NewExpectation().AndRespondWithGraphQLData(nil).AndRespondWithGraphQLErrors(GraphQLError{Message: "boom"}),
+			`{"errors":[{"message":"boom"}],"data":null}`},
+	}
+	for _, tc := range cases {
+		resp := tc.exp.Responses[0]
+		if string(resp.Body) != tc.want {
+			t.Errorf("%s: expected body %s, got %s", tc.name, tc.want, resp.Body)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Headers["Content-Type"] != "application/json" {
+			t.Errorf("%s: unexpected status %d or headers %v", tc.name, resp.StatusCode, resp.Headers)
+		}
+	}
+}
+
+func TestMockServer_GraphQL(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/graphql").
+		WithGraphQLOperation("ListUsers").
+		AndRespondWithGraphQLData(map[string]interface{}{"users": []string{}}))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/graphql").
+		WithGraphQLOperation("GetUser").
+		WithGraphQLVariables(`{"id":"42"}`).
+		AndRespondWithGraphQLData(map[string]interface{}{"user": map[string]string{"id": "42", "name": "Alice"}}))
+
+	resp, err := http.Post(ms.URL()+"/graphql", "application/json", strings.NewReader(getUserQuery))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer safeClose(t, resp.Body)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected status %d or content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if string(body) != `{"data":{"user":{"id":"42","name":"Alice"}}}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
@@ -0,0 +1,157 @@
+package moxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// OnRequest registers fn to be called with every journaled request, matched,
+// unmatched or proxied. Hooks run on the goroutine serving the request, after
+// it is journaled and before the response is sent (for proxied requests, once
+// the upstream exchange completes), so they should not block.
+// Example: ms.OnRequest(func(r RecordedRequest) { t.Logf("%s %s", r.Method, r.URL) })
+func (m *MockServer) OnRequest(fn func(RecordedRequest)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requestHooks = append(m.requestHooks, fn)
+}
+
+// OnMatch registers fn to be called with every request matched by exp.
+// Example: ms.OnMatch(exp, func(r RecordedRequest) { received <- r.Body })
+func (m *MockServer) OnMatch(exp *Expectation, fn func(RecordedRequest)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.matchHooks == nil {
+		m.matchHooks = make(map[*Expectation][]func(RecordedRequest))
+	}
+	m.matchHooks[exp] = append(m.matchHooks[exp], fn)
+}
+
+// OnUnmatched registers fn to be called with every request that matched no
+// expectation and was not proxied.
+// Example: ms.OnUnmatched(func(r UnmatchedRequest) { t.Errorf("unexpected %s %s", r.Method, r.URL) })
+func (m *MockServer) OnUnmatched(fn func(UnmatchedRequest)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unmatchedHooks = append(m.unmatchedHooks, fn)
+}
+
+// WaitForCalls blocks until exp has been matched at least n times, or
+// returns an error once timeout has elapsed.
+// Example: err := ms.WaitForCalls(exp, 3, time.Second)
+func (m *MockServer) WaitForCalls(exp *Expectation, n int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		if exp.InvocationCount >= n {
+			m.mu.Unlock()
+			return nil
+		}
+		changed := m.journalSignal()
+		m.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			m.mu.RLock()
+			defer m.mu.RUnlock()
+			return &ExpectationError{
+				Message: fmt.Sprintf("timed out after %s waiting for %d calls", timeout, n),
+				Details: []string{exp.String()},
+			}
+		}
+	}
+}
+
+// WaitForRequest blocks until a journaled request satisfies matcher and
+// returns it, or returns an error once timeout has elapsed. Requests
+// journaled before the call are considered too, oldest first.
+// Example: req, err := ms.WaitForRequest(func(r RecordedRequest) bool { return r.URL == "/webhook" }, time.Second)
+func (m *MockServer) WaitForRequest(matcher func(RecordedRequest) bool, timeout time.Duration) (RecordedRequest, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	seen, resets := 0, m.journalResetCount()
+	for {
+		m.mu.Lock()
+		if m.journalResets != resets {
+			// The journal was cleared, possibly refilled since: rescan it.
+			seen, resets = 0, m.journalResets
+		}
+		pending := make([]RecordedRequest, 0, len(m.requests)-seen)
+		for _, record := range m.requests[seen:] {
+			pending = append(pending, record.snapshot())
+		}
+		seen = len(m.requests)
+		changed := m.journalSignal()
+		m.mu.Unlock()
+		for _, record := range pending {
+			if matcher(record) {
+				return record, nil
+			}
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			var journaled []string
+			for _, record := range m.GetRecordedRequests() {
+				journaled = append(journaled, record.Method+" "+record.URL)
+			}
+			return RecordedRequest{}, &ExpectationError{
+				Message: fmt.Sprintf("timed out after %s waiting for a matching request", timeout),
+				Details: journaled,
+			}
+		}
+	}
+}
+
+// journal appends record to the request journal and wakes up waiters.
+// Callers must hold m.mu.
+func (m *MockServer) journal(record RecordedRequest) {
+	m.requests = append(m.requests, record)
+	if m.journalChanged != nil {
+		close(m.journalChanged)
+		m.journalChanged = nil
+	}
+}
+
+// journalResetCount returns how many times the journal has been cleared.
+func (m *MockServer) journalResetCount() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.journalResets
+}
+
+// journalSignal returns a channel closed when the next request is journaled.
+// Callers must hold m.mu.
+func (m *MockServer) journalSignal() chan struct{} {
+	if m.journalChanged == nil {
+		m.journalChanged = make(chan struct{})
+	}
+	return m.journalChanged
+}
+
+// runHooks calls the hooks registered for a journaled request. unmatched is
+// nil unless the request matched no expectation and was not proxied.
+// Callers must not hold m.mu.
+func (m *MockServer) runHooks(record RecordedRequest, unmatched *UnmatchedRequest) {
+	m.mu.RLock()
+	requestHooks := m.requestHooks
+	var matchHooks []func(RecordedRequest)
+	if record.Matched {
+		matchHooks = m.matchHooks[record.Expectation]
+	}
+	unmatchedHooks := m.unmatchedHooks
+	m.mu.RUnlock()
+
+	for _, fn := range requestHooks {
+		fn(record)
+	}
+	for _, fn := range matchHooks {
+		fn(record)
+	}
+	if unmatched != nil {
+		for _, fn := range unmatchedHooks {
+			fn(*unmatched)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package moxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooks_Callbacks(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ping := addPing(ms)
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	ms.OnRequest(func(r RecordedRequest) { record("request " + r.URL) })
+	ms.OnMatch(ping, func(r RecordedRequest) {
+		if r.Expectation != ping {
+			t.Errorf("expected the matched expectation, got %v", r.Expectation)
+		}
+		record("match " + r.URL)
+	})
+	ms.OnUnmatched(func(r UnmatchedRequest) { record("unmatched " + r.URL + " " + r.Mismatches[0]) })
+
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	getWith(t, ms.Client(), ms.URL()+"/missing")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"request /ping",
+		"match /ping",
+		"request /missing",
+		"unmatched /missing GET ^/ping$",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected events %q, got %q", want, events)
+	}
+	for i := range want {
+		if !strings.HasPrefix(events[i], want[i]) {
+			t.Errorf("event %d: expected %q, got %q", i, want[i], events[i])
+		}
+	}
+}
+
+func TestHooks_ProxiedRequests(t *testing.T) {
+	upstream := NewMockServer()
+	defer upstream.Close()
+	addPing(upstream)
+	cfg := &Config{}
+	cfg.ProxyUnmatchedTo(upstream.URL())
+	ms := NewMockServerWithConfig(cfg)
+	defer ms.Close()
+
+	proxied := make(chan RecordedRequest, 1)
+	ms.OnRequest(func(r RecordedRequest) { proxied <- r })
+	ms.OnUnmatched(func(r UnmatchedRequest) { t.Errorf("unexpected unmatched hook for %s", r.URL) })
+
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	if r := <-proxied; !r.Proxied || r.Upstream == nil || r.Upstream.StatusCode != http.StatusOK {
+		t.Errorf("expected the proxied request with its upstream response, got %+v", r)
+	}
+}
+
+func TestHooks_WaitForCalls(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ping := addPing(ms)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+			resp, err := ms.Client().Get(ms.URL() + "/ping")
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}
+	}()
+	if err := ms.WaitForCalls(ping, 3, 2*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := ping.InvocationCounter(); n < 3 {
+		t.Errorf("expected at least 3 calls, got %d", n)
+	}
+
+	err := ms.WaitForCalls(ping, 10, 50*time.Millisecond)
+	var expErr *ExpectationError
+	if !errors.As(err, &expErr) || !strings.Contains(expErr.Message, "waiting for 10 calls") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if len(expErr.Details) != 1 || !strings.Contains(expErr.Details[0], "(called: 3") {
+		t.Errorf("expected the error to report the call count, got %v", expErr.Details)
+	}
+}
+
+func TestHooks_WaitForRequest(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	isPing := func(r RecordedRequest) bool { return r.URL == "/ping" }
+	if r, err := ms.WaitForRequest(isPing, 0); err != nil || r.Method != "GET" {
+		t.Fatalf("expected an already journaled request to satisfy the wait, got %+v, %v", r, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		resp, err := ms.Client().Post(ms.URL()+"/webhook", "application/json", strings.NewReader(`{"event":"paid"}`))
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	r, err := ms.WaitForRequest(func(r RecordedRequest) bool { return r.URL == "/webhook" }, 2*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Body != `{"event":"paid"}` || r.Matched {
+		t.Errorf("unexpected webhook request: %+v", r)
+	}
+
+	_, err = ms.WaitForRequest(func(r RecordedRequest) bool { return r.Method == "DELETE" }, 50*time.Millisecond)
+	var expErr *ExpectationError
+	if !errors.As(err, &expErr) || len(expErr.Details) != 2 || expErr.Details[1] != "POST /webhook" {
+		t.Errorf("expected a timeout error listing the journal, got %v", err)
+	}
+}
+
+func TestHooks_WaitForRequestAfterClear(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+
+	found := make(chan error, 1)
+	go func() {
+		_, err := ms.WaitForRequest(func(r RecordedRequest) bool { return r.URL == "/target" }, 2*time.Second)
+		found <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // let the wait scan the two requests
+
+	// Clear and refill the journal past its old length before the wait wakes
+	// up, with the match at the start.
+	ms.ClearRecordedRequests()
+	ms.mu.Lock()
+	for _, url := range []string{"/target", "/other", "/other"} {
+		ms.journal(RecordedRequest{Method: "GET", URL: url})
+	}
+	ms.mu.Unlock()
+	if err := <-found; err != nil {
+		t.Errorf("expected the request journaled after the clear to be found, got %v", err)
+	}
+}
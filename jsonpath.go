@@ -0,0 +1,430 @@
+package moxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValueMatcher matches a single decoded JSON value. Values are represented the
+// way encoding/json decodes into interface{}: string, float64, bool, nil,
+// []interface{} and map[string]interface{}.
+type ValueMatcher interface {
+	Match(value interface{}) bool
+	String() string
+}
+
+// valueMatcher is a ValueMatcher built from a description and a predicate.
+type valueMatcher struct {
+	description string
+	predicate   func(value interface{}) bool
+}
+
+func (m valueMatcher) Match(value interface{}) bool { return m.predicate(value) }
+func (m valueMatcher) String() string               { return m.description }
+
+// Equals matches values equal to expected after JSON normalization,
+// so Equals(100) matches the JSON number 100.
+func Equals(expected interface{}) ValueMatcher {
+	normalized := normalizeJSONValue(expected)
+	return valueMatcher{
+		description: fmt.Sprintf("equal to %v", formatJSONValue(normalized)),
+		predicate: func(value interface{}) bool {
+			return reflect.DeepEqual(value, normalized)
+		},
+	}
+}
+
+// Contains matches strings containing the expected substring and arrays
+// containing an element equal to expected.
+func Contains(expected interface{}) ValueMatcher {
+	normalized := normalizeJSONValue(expected)
+	return valueMatcher{
+		description: fmt.Sprintf("containing %v", formatJSONValue(normalized)),
+		predicate: func(value interface{}) bool {
+			switch v := value.(type) {
+			case string:
+				s, ok := normalized.(string)
+				return ok && strings.Contains(v, s)
+			case []interface{}:
+				for _, element := range v {
+					if reflect.DeepEqual(element, normalized) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	}
+}
+
+// GreaterThan matches numbers strictly greater than n.
+func GreaterThan(n float64) ValueMatcher {
+	return numberMatcher(fmt.Sprintf("> %v", n), func(v float64) bool { return v > n })
+}
+
+// GreaterThanOrEqual matches numbers greater than or equal to n.
+func GreaterThanOrEqual(n float64) ValueMatcher {
+	return numberMatcher(fmt.Sprintf(">= %v", n), func(v float64) bool { return v >= n })
+}
+
+// LessThan matches numbers strictly less than n.
+func LessThan(n float64) ValueMatcher {
+	return numberMatcher(fmt.Sprintf("< %v", n), func(v float64) bool { return v < n })
+}
+
+// LessThanOrEqual matches numbers less than or equal to n.
+func LessThanOrEqual(n float64) ValueMatcher {
+	return numberMatcher(fmt.Sprintf("<= %v", n), func(v float64) bool { return v <= n })
+}
+
+func numberMatcher(description string, predicate func(float64) bool) ValueMatcher {
+	return valueMatcher{
+		description: description,
+		predicate: func(value interface{}) bool {
+			n, ok := value.(float64)
+			return ok && predicate(n)
+		},
+	}
+}
+
+// MatchesRegex matches strings matching the regular expression pattern.
+// It panics if pattern is invalid.
+func MatchesRegex(pattern string) ValueMatcher {
+	re := regexp.MustCompile(pattern)
+	return valueMatcher{
+		description: fmt.Sprintf("matching /%s/", pattern),
+		predicate: func(value interface{}) bool {
+			s, ok := value.(string)
+			return ok && re.MatchString(s)
+		},
+	}
+}
+
+// IsType matches values of the given JSON type: "string", "number",
+// "integer", "boolean", "null", "array" or "object".
+func IsType(jsonType string) ValueMatcher {
+	return valueMatcher{
+		description: "of type " + jsonType,
+		predicate: func(value interface{}) bool {
+			actual := jsonTypeOf(value)
+			return actual == jsonType || (jsonType == "number" && actual == "integer")
+		},
+	}
+}
+
+// Exists matches any value, including null. It only fails if the path is absent.
+func Exists() ValueMatcher {
+	return valueMatcher{
+		description: "present",
+		predicate:   func(interface{}) bool { return true },
+	}
+}
+
+// AnyElement matches arrays containing at least one element matching m.
+// Example: WithJSONPath("$.items", AnyElement(HasField("sku", Equals("A-1"))))
+func AnyElement(m ValueMatcher) ValueMatcher {
+	return valueMatcher{
+		description: fmt.Sprintf("an array with an element %s", m),
+		predicate: func(value interface{}) bool {
+			elements, ok := value.([]interface{})
+			if !ok {
+				return false
+			}
+			for _, element := range elements {
+				if m.Match(element) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// EveryElement matches non-empty arrays whose elements all match m.
+func EveryElement(m ValueMatcher) ValueMatcher {
+	return valueMatcher{
+		description: fmt.Sprintf("an array whose elements are all %s", m),
+		predicate: func(value interface{}) bool {
+			elements, ok := value.([]interface{})
+			if !ok || len(elements) == 0 {
+				return false
+			}
+			for _, element := range elements {
+				if !m.Match(element) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// HasField matches objects that have a field key whose value matches m.
+func HasField(key string, m ValueMatcher) ValueMatcher {
+	return valueMatcher{
+		description: fmt.Sprintf("an object with %q %s", key, m),
+		predicate: func(value interface{}) bool {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			field, exists := object[key]
+			return exists && m.Match(field)
+		},
+	}
+}
+
+// Not inverts a matcher.
+func Not(m ValueMatcher) ValueMatcher {
+	return valueMatcher{
+		description: fmt.Sprintf("not %s", m),
+		predicate:   func(value interface{}) bool { return !m.Match(value) },
+	}
+}
+
+// WithJSONPath adds a matcher for the value selected by a JSONPath expression.
+// All JSONPath matchers must match. A path containing a wildcard selects an
+// array of every matching value, so Contains and AnyElement apply to it.
+// Supported syntax: $, .field, ['field'], [index] (negative indices count from
+// the end), [*] and .*
+// Example: .WithJSONPath("$.items[*].sku", Contains("A-1")).WithJSONPath("$.total", GreaterThan(100))
+func (e *Expectation) WithJSONPath(path string, matcher ValueMatcher) *Expectation {
+	steps, err := compileJSONPath(path)
+	if err != nil {
+		panic(fmt.Sprintf("invalid JSONPath %q: %v", path, err))
+	}
+	e.Request.JSONPaths = append(e.Request.JSONPaths, JSONPathExpectation{
+		Path:    path,
+		Matcher: matcher,
+		steps:   steps,
+	})
+	return e
+}
+
+// jsonPathMismatch evaluates JSONPath matchers against body and explains the
+// first mismatch. It returns an empty string if all matchers match.
+func jsonPathMismatch(paths []JSONPathExpectation, body []byte) string {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return fmt.Sprintf("json path: body is not valid JSON: %v", err)
+	}
+	for _, p := range paths {
+		value, found := evaluateJSONPath(p.steps, document)
+		if !found {
+			return fmt.Sprintf("json path %q: not found, expected %s", p.Path, p.Matcher)
+		}
+		if !p.Matcher.Match(value) {
+			return fmt.Sprintf("json path %q: expected %s, got %s", p.Path, p.Matcher, formatJSONValue(value))
+		}
+	}
+	return ""
+}
+
+// jsonPathStep is a single step of a compiled JSONPath expression.
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// compileJSONPath parses the supported JSONPath subset into steps.
+func compileJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path must start with $")
+	}
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			return nil, fmt.Errorf("recursive descent is not supported")
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("empty field name")
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{key: name})
+			}
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "["):
+			inner := strings.TrimLeft(rest[1:], " ")
+			if inner != "" && (inner[0] == '\'' || inner[0] == '"') {
+				key, n, err := readQuotedKey(inner)
+				if err != nil {
+					return nil, err
+				}
+				inner = strings.TrimLeft(inner[n:], " ")
+				if !strings.HasPrefix(inner, "]") {
+					return nil, fmt.Errorf("expected ] after quoted key %q", key)
+				}
+				steps = append(steps, jsonPathStep{key: key})
+				rest = inner[1:]
+				continue
+			}
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated bracket")
+			}
+			inner = strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if inner == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q", inner)
+			}
+			steps = append(steps, jsonPathStep{index: index, isIndex: true})
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+	}
+	return steps, nil
+}
+
+// readQuotedKey reads the quoted key at the start of s, which may contain
+// brackets and backslash-escaped characters, and returns it with the number
+// of bytes consumed.
+func readQuotedKey(s string) (key string, n int, err error) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 == len(s) {
+				return "", 0, fmt.Errorf("unterminated quoted key")
+			}
+			i++
+			sb.WriteByte(s[i])
+		case quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted key")
+}
+
+// evaluateJSONPath applies steps to document. Once a wildcard is applied the
+// result is the array of all values that resolve; otherwise it is the single
+// value found, and found is false if the path does not exist.
+func evaluateJSONPath(steps []jsonPathStep, document interface{}) (value interface{}, found bool) {
+	current := []interface{}{document}
+	multiple := false
+	for _, step := range steps {
+		var next []interface{}
+		for _, node := range current {
+			next = append(next, step.apply(node)...)
+		}
+		if step.wildcard {
+			multiple = true
+		}
+		current = next
+	}
+	if multiple {
+		if current == nil {
+			current = []interface{}{}
+		}
+		return current, true
+	}
+	if len(current) == 0 {
+		return nil, false
+	}
+	return current[0], true
+}
+
+// apply returns the values selected by the step from node.
+func (s jsonPathStep) apply(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			values := make([]interface{}, 0, len(v))
+			for _, key := range sortedKeys(v) {
+				values = append(values, v[key])
+			}
+			return values
+		}
+		if child, ok := v[s.key]; ok && !s.isIndex {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		if s.wildcard {
+			return v
+		}
+		if s.isIndex {
+			index := s.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index >= 0 && index < len(v) {
+				return []interface{}{v[index]}
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeJSONValue converts a Go value into its encoding/json interface{}
+// representation, e.g. int 100 becomes float64 100.
+func normalizeJSONValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// formatJSONValue renders a decoded JSON value for diagnostics.
+func formatJSONValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return truncate(data, 64)
+}
+
+// jsonTypeOf returns the JSON type name of a decoded value.
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if isJSONInteger(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// isJSONInteger reports whether value is a number without a fractional part.
+func isJSONInteger(value interface{}) bool {
+	n, ok := value.(float64)
+	return ok && n == float64(int64(n))
+}
@@ -0,0 +1,150 @@
+package moxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const orderJSON = `{
+	"id": "ord-1",
+	"total": 149.5,
+	"customer": {"name": "Alice", "email": "alice@example.com"},
+	"items": [
+		{"sku": "A-1", "qty": 2},
+		{"sku": "B-7", "qty": 1}
+	],
+	"tags": ["gift", "express"],
+	"coupon": null
+}`
+
+func TestWithJSONPath_Matchers(t *testing.T) {
+	cases := []struct {
+		name    string
+		path    string
+		matcher ValueMatcher
+		match   bool
+	}{
+		{"equals string", "$.id", Equals("ord-1"), true},
+		{"equals nested", "$.customer.name", Equals("Alice"), true},
+		{"equals int normalized", "$.items[0].qty", Equals(2), true},
+		{"bracket notation", "$['customer']['email']", Equals("alice@example.com"), true},
+		{"negative index", "$.items[-1].sku", Equals("B-7"), true},
+		{"wildcard contains", "$.items[*].sku", Contains("A-1"), true},
+		{"wildcard contains missing", "$.items[*].sku", Contains("Z-9"), false},
+		{"array contains", "$.tags", Contains("gift"), true},
+		{"string contains", "$.customer.email", Contains("@example"), true},
+		{"greater than", "$.total", GreaterThan(100), true},
+		{"greater than fails", "$.total", GreaterThan(200), false},
+		{"less than or equal", "$.total", LessThanOrEqual(149.5), true},
+		{"regex", "$.customer.email", MatchesRegex(`^[a-z]+@example\.com$`), true},
+		{"regex on number", "$.total", MatchesRegex(`.*`), false},
+		{"type string", "$.id", IsType("string"), true},
+		{"type integer", "$.items[0].qty", IsType("integer"), true},
+		{"integer is number", "$.items[0].qty", IsType("number"), true},
+		{"float not integer", "$.total", IsType("integer"), false},
+		{"type null", "$.coupon", IsType("null"), true},
+		{"type array", "$.items", IsType("array"), true},
+		{"exists null", "$.coupon", Exists(), true},
+		{"any element", "$.items", AnyElement(HasField("qty", GreaterThan(1))), true},
+		{"any element fails", "$.items", AnyElement(HasField("qty", GreaterThan(5))), false},
+		{"every element", "$.items[*].qty", EveryElement(GreaterThanOrEqual(1)), true},
+		{"every element fails", "$.items[*].qty", EveryElement(GreaterThan(1)), false},
+		{"not", "$.id", Not(Equals("ord-2")), true},
+		{"missing path", "$.shipping.address", Exists(), false},
+		{"index out of range", "$.items[5]", Exists(), false},
+	}
+	r, _ := http.NewRequest("POST", "/orders", nil)
+	for _, tc := range cases {
+		e := NewExpectation().
+			WithRequestMethod("POST").
+			WithPath("/orders").
+			WithJSONPath(tc.path, tc.matcher)
+		if got := e.matches(r, []byte(orderJSON)); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v (%s)", tc.name, tc.match, got, e.mismatch(r, []byte(orderJSON)))
+		}
+	}
+}
+
+func TestWithJSONPath_MultipleMatchersAreANDed(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/orders").
+		WithJSONPath("$.items[*].sku", Contains("A-1")).
+		WithJSONPath("$.total", GreaterThan(100))
+
+	r, _ := http.NewRequest("POST", "/orders", nil)
+	if !e.matches(r, []byte(orderJSON)) {
+		t.Errorf("expected match, got: %s", e.mismatch(r, []byte(orderJSON)))
+	}
+	cheap := strings.Replace(orderJSON, "149.5", "50", 1)
+	reason := e.mismatch(r, []byte(cheap))
+	if !strings.Contains(reason, `"$.total"`) || !strings.Contains(reason, "> 100") || !strings.Contains(reason, "got 50") {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+	if reason := e.mismatch(r, []byte("not json")); !strings.Contains(reason, "not valid JSON") {
+		t.Errorf("unexpected mismatch reason for invalid JSON: %q", reason)
+	}
+}
+
+func TestWithJSONPath_QuotedKeys(t *testing.T) {
+	body := []byte(`{"a]b": 1, "it's": 2, "x.y": {"[z]": 3}}`)
+	r, _ := http.NewRequest("POST", "/orders", nil)
+	for path, want := range map[string]int{
+		`$['a]b']`:        1,
+		`$[ "a]b" ]`:      1,
+		`$['it\'s']`:      2,
+		`$["x.y"]['[z]']`: 3,
+	} {
+		e := NewExpectation().WithRequestMethod("POST").WithJSONPath(path, Equals(want))
+		if !e.matches(r, body) {
+			t.Errorf("%s: expected match, got: %s", path, e.mismatch(r, body))
+		}
+	}
+}
+
+func TestWithJSONPath_InvalidPathPanics(t *testing.T) {
+	for _, path := range []string{"items", "$..sku", "$.items[", "$.items[x]", "$.", "$['a]b'", "$['a'x]"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for invalid path %q", path)
+				}
+			}()
+			NewExpectation().WithJSONPath(path, Exists())
+		}()
+	}
+}
+
+// TestMockServer_UnmatchedMismatchDiagnostics verifies that unmatched requests
+// record why each expectation did not match.
+func TestMockServer_UnmatchedMismatchDiagnostics(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{LogUnmatched: false})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/orders").
+		WithJSONPath("$.total", GreaterThan(100)).
+		AndRespondWithString("ok", 200))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/orders").
+		AndRespondWithString("ok", 200))
+
+	resp, err := http.Post(ms.URL()+"/orders", "application/json", strings.NewReader(`{"total": 10}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	unmatched := ms.GetUnmatchedRequests()
+	if len(unmatched) != 1 || len(unmatched[0].Mismatches) != 2 {
+		t.Fatalf("expected one unmatched request with two mismatches, got %+v", unmatched)
+	}
+	if !strings.Contains(unmatched[0].Mismatches[0], `json path "$.total": expected > 100, got 10`) {
+		t.Errorf("unexpected first mismatch: %q", unmatched[0].Mismatches[0])
+	}
+	if !strings.Contains(unmatched[0].Mismatches[1], `method: expected "GET", got "POST"`) {
+		t.Errorf("unexpected second mismatch: %q", unmatched[0].Mismatches[1])
+	}
+}
@@ -0,0 +1,313 @@
+package moxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"unicode/utf8"
+)
+
+// JSONSchema is a compiled JSON Schema supporting a subset of draft 2020-12:
+// type, enum, const, required, properties, additionalProperties, items,
+// pattern, minLength, maxLength, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minItems and maxItems. Unknown keywords are ignored, as
+// the specification requires. Patterns use Go regular expression syntax.
+type JSONSchema struct {
+	// Boolean schemas: true accepts everything, false rejects everything.
+	rejectAll bool
+
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	required             []string
+	properties           map[string]*JSONSchema
+	additionalProperties *JSONSchema
+	items                *JSONSchema
+	pattern              *regexp.Regexp
+	minLength, maxLength *int
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minItems, maxItems   *int
+}
+
+// CompileJSONSchema parses and compiles a JSON Schema document.
+func CompileJSONSchema(schema string) (*JSONSchema, error) {
+	var document interface{}
+	if err := json.Unmarshal([]byte(schema), &document); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return compileSchemaNode(document, "#")
+}
+
+// WithRequestJSONSchema sets a matcher that accepts any request body that
+// validates against the given JSON Schema. It panics if the schema is invalid.
+// Example: .WithRequestJSONSchema(`{"type":"object","required":["id"]}`)
+func (e *Expectation) WithRequestJSONSchema(schema string) *Expectation {
+	compiled, err := CompileJSONSchema(schema)
+	if err != nil {
+		panic(err)
+	}
+	e.Request.JSONSchema = compiled
+	return e
+}
+
+// Validate checks that data is a JSON document valid against the schema.
+// The returned error describes the first violation and where it occurred.
+func (s *JSONSchema) Validate(data []byte) error {
+	var instance interface{}
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return fmt.Errorf("body is not valid JSON: %w", err)
+	}
+	return s.validate(instance, "$")
+}
+
+func compileSchemaNode(node interface{}, location string) (*JSONSchema, error) {
+	switch v := node.(type) {
+	case bool:
+		return &JSONSchema{rejectAll: !v}, nil
+	case map[string]interface{}:
+		return compileSchemaObject(v, location)
+	}
+	return nil, fmt.Errorf("%s: schema must be an object or boolean", location)
+}
+
+func compileSchemaObject(node map[string]interface{}, location string) (*JSONSchema, error) {
+	s := &JSONSchema{}
+	var err error
+
+	switch t := node["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: entries must be strings", location)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or array", location)
+	}
+	for _, name := range s.types {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fmt.Errorf("%s/type: unknown type %q", location, name)
+		}
+	}
+
+	if enum, ok := node["enum"]; ok {
+		values, ok := enum.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", location)
+		}
+		s.enum = values
+	}
+	if constValue, ok := node["const"]; ok {
+		s.constValue, s.hasConst = constValue, true
+	}
+
+	if required, ok := node["required"]; ok {
+		names, ok := required.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array", location)
+		}
+		for _, name := range names {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: entries must be strings", location)
+			}
+			s.required = append(s.required, str)
+		}
+	}
+
+	if properties, ok := node["properties"]; ok {
+		props, ok := properties.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", location)
+		}
+		s.properties = make(map[string]*JSONSchema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compileSchemaNode(sub, location+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if additional, ok := node["additionalProperties"]; ok {
+		if s.additionalProperties, err = compileSchemaNode(additional, location+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if items, ok := node["items"]; ok {
+		if s.items, err = compileSchemaNode(items, location+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	if pattern, ok := node["pattern"]; ok {
+		str, ok := pattern.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", location)
+		}
+		if s.pattern, err = regexp.Compile(str); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", location, err)
+		}
+	}
+
+	for keyword, target := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if value, ok := node[keyword]; ok {
+			n, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: must be a number", location, keyword)
+			}
+			*target = &n
+		}
+	}
+	for keyword, target := range map[string]**int{
+		"minLength": &s.minLength,
+		"maxLength": &s.maxLength,
+		"minItems":  &s.minItems,
+		"maxItems":  &s.maxItems,
+	} {
+		if value, ok := node[keyword]; ok {
+			if !isJSONInteger(value) || value.(float64) < 0 {
+				return nil, fmt.Errorf("%s/%s: must be a non-negative integer", location, keyword)
+			}
+			n := int(value.(float64))
+			*target = &n
+		}
+	}
+	return s, nil
+}
+
+// validate checks instance against the schema; path is the JSONPath of instance.
+func (s *JSONSchema) validate(instance interface{}, path string) error {
+	if s.rejectAll {
+		return fmt.Errorf("%s: not allowed", path)
+	}
+
+	if len(s.types) > 0 {
+		actual := jsonTypeOf(instance)
+		matched := false
+		for _, expected := range s.types {
+			if actual == expected || (expected == "number" && actual == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected type %s, got %s", path, formatJSONValue(s.types), actual)
+		}
+	}
+	if s.enum != nil && !containsJSONValue(s.enum, instance) {
+		return fmt.Errorf("%s: %s is not one of %s", path, formatJSONValue(instance), formatJSONValue(s.enum))
+	}
+	if s.hasConst && !reflect.DeepEqual(instance, s.constValue) {
+		return fmt.Errorf("%s: expected %s, got %s", path, formatJSONValue(s.constValue), formatJSONValue(instance))
+	}
+
+	switch v := instance.(type) {
+	case string:
+		return s.validateString(v, path)
+	case float64:
+		return s.validateNumber(v, path)
+	case []interface{}:
+		return s.validateArray(v, path)
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateString(v, path string) error {
+	length := utf8.RuneCountInString(v)
+	if s.minLength != nil && length < *s.minLength {
+		return fmt.Errorf("%s: length %d is less than minLength %d", path, length, *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		return fmt.Errorf("%s: length %d is greater than maxLength %d", path, length, *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		return fmt.Errorf("%s: %q does not match pattern %s", path, v, s.pattern)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateNumber(v float64, path string) error {
+	if s.minimum != nil && v < *s.minimum {
+		return fmt.Errorf("%s: %v is less than minimum %v", path, v, *s.minimum)
+	}
+	if s.maximum != nil && v > *s.maximum {
+		return fmt.Errorf("%s: %v is greater than maximum %v", path, v, *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+		return fmt.Errorf("%s: %v is not greater than exclusiveMinimum %v", path, v, *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+		return fmt.Errorf("%s: %v is not less than exclusiveMaximum %v", path, v, *s.exclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateArray(v []interface{}, path string) error {
+	if s.minItems != nil && len(v) < *s.minItems {
+		return fmt.Errorf("%s: %d items is less than minItems %d", path, len(v), *s.minItems)
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		return fmt.Errorf("%s: %d items is greater than maxItems %d", path, len(v), *s.maxItems)
+	}
+	if s.items != nil {
+		for i, item := range v {
+			if err := s.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateObject(v map[string]interface{}, path string) error {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	for _, name := range sortedKeys(v) {
+		childPath := path + "." + name
+		if sub, ok := s.properties[name]; ok {
+			if err := sub.validate(v[name], childPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.additionalProperties != nil {
+			if s.additionalProperties.rejectAll {
+				return fmt.Errorf("%s: additional property %q is not allowed", path, name)
+			}
+			if err := s.additionalProperties.validate(v[name], childPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// containsJSONValue reports whether values contains a value deeply equal to v.
+func containsJSONValue(values []interface{}, v interface{}) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
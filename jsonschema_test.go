@@ -0,0 +1,132 @@
+package moxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const orderSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["id", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "string", "pattern": "^ord-[0-9]+$"},
+		"status": {"enum": ["new", "paid"]},
+		"total": {"type": "number", "minimum": 0, "exclusiveMaximum": 10000},
+		"note": {"type": ["string", "null"], "maxLength": 10},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"required": ["sku", "qty"],
+				"properties": {
+					"sku": {"type": "string", "minLength": 3},
+					"qty": {"type": "integer", "minimum": 1, "maximum": 99}
+				}
+			}
+		}
+	}
+}`
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema, err := CompileJSONSchema(orderSchema)
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"valid minimal", `{"id":"ord-1","items":[{"sku":"A-1","qty":1}]}`, ""},
+		{"valid full", `{"id":"ord-2","status":"paid","total":12.5,"note":null,"items":[{"sku":"A-1","qty":2,"extra":true}]}`, ""},
+		{"missing required", `{"id":"ord-1"}`, `$: missing required property "items"`},
+		{"wrong type", `{"id":1,"items":[{"sku":"A-1","qty":1}]}`, `$.id: expected type ["string"], got integer`},
+		{"pattern", `{"id":"order-1","items":[{"sku":"A-1","qty":1}]}`, `$.id: "order-1" does not match pattern`},
+		{"enum", `{"id":"ord-1","status":"lost","items":[{"sku":"A-1","qty":1}]}`, `$.status: "lost" is not one of`},
+		{"minimum", `{"id":"ord-1","total":-1,"items":[{"sku":"A-1","qty":1}]}`, `$.total: -1 is less than minimum 0`},
+		{"exclusive maximum", `{"id":"ord-1","total":10000,"items":[{"sku":"A-1","qty":1}]}`, `exclusiveMaximum`},
+		{"max length", `{"id":"ord-1","note":"far too long note","items":[{"sku":"A-1","qty":1}]}`, `$.note: length 17 is greater than maxLength 10`},
+		{"min items", `{"id":"ord-1","items":[]}`, `$.items: 0 items is less than minItems 1`},
+		{"nested item", `{"id":"ord-1","items":[{"sku":"A-1","qty":1},{"sku":"B","qty":1}]}`, `$.items[1].sku: length 1 is less than minLength 3`},
+		{"integer", `{"id":"ord-1","items":[{"sku":"A-1","qty":1.5}]}`, `$.items[0].qty: expected type ["integer"], got number`},
+		{"additional properties", `{"id":"ord-1","items":[{"sku":"A-1","qty":1}],"coupon":"X"}`, `additional property "coupon" is not allowed`},
+		{"not json", `{`, `body is not valid JSON`},
+	}
+	for _, tc := range cases {
+		err := schema.Validate([]byte(tc.body))
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestJSONSchema_BooleanAndTypedAdditionalProperties(t *testing.T) {
+	schema, err := CompileJSONSchema(`{"type":"object","additionalProperties":{"type":"integer"}}`)
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	if err := schema.Validate([]byte(`{"a":1,"b":2}`)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := schema.Validate([]byte(`{"a":"x"}`)); err == nil {
+		t.Error("expected typed additionalProperties to reject a string")
+	}
+
+	anything, _ := CompileJSONSchema(`true`)
+	nothing, _ := CompileJSONSchema(`false`)
+	if anything.Validate([]byte(`[1,"x"]`)) != nil || nothing.Validate([]byte(`1`)) == nil {
+		t.Error("unexpected boolean schema behavior")
+	}
+}
+
+func TestJSONSchema_InvalidSchemas(t *testing.T) {
+	for _, schema := range []string{
+		`{`,
+		`"object"`,
+		`{"type":"thing"}`,
+		`{"type":5}`,
+		`{"required":"id"}`,
+		`{"pattern":"("}`,
+		`{"minLength":-1}`,
+		`{"minimum":"0"}`,
+		`{"properties":{"a":1}}`,
+	} {
+		if _, err := CompileJSONSchema(schema); err == nil {
+			t.Errorf("expected compile error for %s", schema)
+		}
+	}
+}
+
+func TestWithRequestJSONSchema(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/orders").
+		WithRequestJSONSchema(orderSchema)
+
+	r, _ := http.NewRequest("POST", "/orders", nil)
+	if !e.matches(r, []byte(`{"id":"ord-9","items":[{"sku":"XYZ","qty":3}]}`)) {
+		t.Error("expected structurally valid body to match")
+	}
+	reason := e.mismatch(r, []byte(`{"id":"ord-9","items":[]}`))
+	if reason != "json schema: $.items: 0 items is less than minItems 1" {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for invalid schema")
+		}
+	}()
+	NewExpectation().WithRequestJSONSchema(`{"type":"thing"}`)
+}
@@ -0,0 +1,183 @@
+package moxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Stop takes the server down without discarding its state: the listener is
+// closed, so new connections are refused, and open client connections are
+// closed. Expectations, virtual hosts and the request journal are kept, and
+// Start brings the server back on the same address.
+// Example:
+//
+//	ms.Stop()
+//	// ... exercise the client's retry logic ...
+//	if err := ms.Start(); err != nil { t.Fatal(err) }
+func (m *MockServer) Stop() {
+	_ = m.listener.setMode(RefuseConnections)
+	m.CloseClientConnections()
+}
+
+// Start brings a stopped or paused server back up, rebinding the address it
+// was listening on. It fails if the address has been taken in the meantime.
+// Starting a running server does nothing. A Config.Listener is replaced by a
+// plain listener on its address.
+func (m *MockServer) Start() error {
+	return m.listener.setMode(0)
+}
+
+// Pause simulates downtime for new connections while keeping established
+// connections working: with RefuseConnections the listener is closed, with
+// BlackHoleConnections new connections are accepted but never served, so
+// clients hang until they time out. Resume ends the pause.
+func (m *MockServer) Pause(mode PauseMode) error {
+	if mode != RefuseConnections && mode != BlackHoleConnections {
+		return fmt.Errorf("invalid pause mode %d", mode)
+	}
+	return m.listener.setMode(mode)
+}
+
+// Resume ends a pause, rebinding the address if connections were refused.
+// Connections black-holed during the pause are closed.
+func (m *MockServer) Resume() error {
+	return m.listener.setMode(0)
+}
+
+// CloseClientConnections closes every open client connection, including
+// idle keep-alive connections and CONNECT tunnels of the forward proxy,
+// without stopping the server, so clients must reconnect.
+func (m *MockServer) CloseClientConnections() {
+	m.listener.closeHeld()
+	if m.server != nil {
+		m.server.CloseClientConnections()
+	}
+	m.closeTunnels()
+}
+
+// pausableListener wraps the server's listener so that Pause and Stop can
+// refuse or black-hole new connections without stopping the http.Server.
+// With Config.InMemory it has no underlying listener and only records the
+// mode for Transport.
+type pausableListener struct {
+	mu      sync.Mutex
+	inner   net.Listener  // nil while refusing connections or in memory
+	addr    net.Addr      // address to rebind when connections are accepted again
+	mode    PauseMode     // 0 while serving
+	held    []net.Conn    // black-holed connections
+	resumed chan struct{} // closed when the listener stops refusing connections
+	closed  bool
+}
+
+func newPausableListener(inner net.Listener) *pausableListener {
+	return &pausableListener{inner: inner, addr: inner.Addr()}
+}
+
+// setMode switches to mode, or back to serving for 0.
+func (l *pausableListener) setMode(mode PauseMode) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errors.New("server is closed")
+	}
+	if mode == RefuseConnections {
+		if l.inner != nil {
+			_ = l.inner.Close()
+			l.inner = nil
+		}
+		if l.resumed == nil {
+			l.resumed = make(chan struct{})
+		}
+	} else if l.resumed != nil {
+		if l.addr != nil {
+			inner, err := net.Listen(l.addr.Network(), l.addr.String())
+			if err != nil {
+				return fmt.Errorf("rebinding %s: %w", l.addr, err)
+			}
+			l.inner = inner
+		}
+		close(l.resumed)
+		l.resumed = nil
+	}
+	if mode != BlackHoleConnections {
+		l.closeHeldLocked()
+	}
+	l.mode = mode
+	return nil
+}
+
+// currentMode returns the pause mode, or 0 while serving.
+func (l *pausableListener) currentMode() PauseMode {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mode
+}
+
+func (l *pausableListener) closeHeld() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closeHeldLocked()
+}
+
+func (l *pausableListener) closeHeldLocked() {
+	for _, conn := range l.held {
+		_ = conn.Close()
+	}
+	l.held = nil
+}
+
+func (l *pausableListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return nil, net.ErrClosed
+		}
+		inner, resumed := l.inner, l.resumed
+		l.mu.Unlock()
+		if inner == nil {
+			<-resumed
+			continue
+		}
+		conn, err := inner.Accept()
+		l.mu.Lock()
+		if err != nil {
+			// The listener was closed by a pause or Close, not by a failure.
+			replaced := l.inner != inner || l.closed
+			l.mu.Unlock()
+			if replaced {
+				continue
+			}
+			return nil, err
+		}
+		if l.mode == BlackHoleConnections {
+			l.held = append(l.held, conn)
+			l.mu.Unlock()
+			continue
+		}
+		l.mu.Unlock()
+		return conn, nil
+	}
+}
+
+func (l *pausableListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.closeHeldLocked()
+	if l.resumed != nil {
+		close(l.resumed)
+		l.resumed = nil
+	}
+	if l.inner != nil {
+		return l.inner.Close()
+	}
+	return nil
+}
+
+func (l *pausableListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,245 @@
+package moxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freshClient returns a client that opens a new connection for every request.
+func freshClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		Timeout:   200 * time.Millisecond,
+	}
+}
+
+func TestLifecycle_StopAndStart(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+	url := ms.URL()
+
+	if status, _ := getWith(t, freshClient(), url+"/ping"); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+
+	ms.Stop()
+	if _, err := freshClient().Get(url + "/ping"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected connection refused while stopped, got %v", err)
+	}
+
+	if err := ms.Start(); err != nil {
+		t.Fatalf("unexpected error restarting: %v", err)
+	}
+	if ms.URL() != url {
+		t.Errorf("expected the server to come back on %s, got %s", url, ms.URL())
+	}
+	if status, body := getWith(t, freshClient(), url+"/ping"); status != http.StatusOK || body != "pong" {
+		t.Errorf("expected expectations to survive a restart, got %d %q", status, body)
+	}
+	if n := len(ms.GetRecordedRequests()); n != 2 {
+		t.Errorf("expected the journal to survive a restart, got %d requests", n)
+	}
+	if err := ms.Start(); err != nil {
+		t.Errorf("expected starting a running server to do nothing, got %v", err)
+	}
+}
+
+func TestLifecycle_StopClosesOpenConnections(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+	client := ms.Client()
+	getWith(t, client, ms.URL()+"/ping")
+
+	ms.Stop()
+	// The idle keep-alive connection was closed, so the request must dial.
+	if _, err := client.Get(ms.URL() + "/ping"); err == nil {
+		t.Error("expected requests to fail while stopped")
+	}
+}
+
+func TestLifecycle_PauseRefusingConnections(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+	addPing(ms)
+	keepAlive := ms.Client()
+	getWith(t, keepAlive, ms.URL()+"/ping")
+
+	if err := ms.Pause(RefuseConnections); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ms.Client().Get(ms.URL() + "/ping"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected new connections to be refused, got %v", err)
+	}
+	if status, _ := getWith(t, keepAlive, ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected the established connection to keep working, got %d", status)
+	}
+
+	if err := ms.Resume(); err != nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if status, _ := getWith(t, ms.Client(), ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected new connections after Resume, got %d", status)
+	}
+}
+
+func TestLifecycle_PauseBlackHoling(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+
+	if err := ms.Pause(BlackHoleConnections); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Now()
+	_, err := freshClient().Get(ms.URL() + "/ping")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected the client to wait for its timeout, returned after %v", elapsed)
+	}
+
+	if err := ms.Resume(); err != nil {
+		t.Fatalf("unexpected error resuming: %v", err)
+	}
+	if status, _ := getWith(t, freshClient(), ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected requests to succeed after Resume, got %d", status)
+	}
+	if n := len(ms.GetRecordedRequests()); n != 1 {
+		t.Errorf("expected black-holed requests not to be journaled, got %d requests", n)
+	}
+}
+
+func TestLifecycle_SwitchingPauseModes(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+
+	if err := ms.Pause(RefuseConnections); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ms.Pause(BlackHoleConnections); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The address is bound again, so the connection is accepted but not served.
+	if _, err := freshClient().Get(ms.URL() + "/ping"); err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if err := ms.Pause(PauseMode(0)); err == nil {
+		t.Error("expected an error for an invalid pause mode")
+	}
+}
+
+func TestLifecycle_CloseClientConnections(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	addPing(ms)
+	var mu sync.Mutex
+	var remoteAddrs []string
+	ms.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			remoteAddrs = append(remoteAddrs, r.RemoteAddr)
+			mu.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	client := ms.Client()
+	getWith(t, client, ms.URL()+"/ping")
+	getWith(t, client, ms.URL()+"/ping")
+	ms.CloseClientConnections()
+	if status, _ := getWith(t, client, ms.URL()+"/ping"); status != http.StatusOK {
+		t.Fatalf("expected the client to reconnect, got %d", status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if remoteAddrs[0] != remoteAddrs[1] {
+		t.Error("expected the connection to be reused before CloseClientConnections")
+	}
+	if remoteAddrs[1] == remoteAddrs[2] {
+		t.Error("expected a new connection after CloseClientConnections")
+	}
+}
+
+func TestLifecycle_StopClosesTunnels(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{ForwardProxy: &ForwardProxyOptions{}})
+	defer ms.Close()
+	addPing(ms)
+
+	conn, err := net.Dial("tcp", ms.server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	_, _ = fmt.Fprint(conn, "CONNECT legacy.internal:80 HTTP/1.1\r\nHost: legacy.internal:80\r\n\r\n")
+	if resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect}); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected CONNECT response: %v %v", resp, err)
+	}
+	_, _ = fmt.Fprint(conn, "GET /ping HTTP/1.1\r\nHost: legacy.internal\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	ms.Stop()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _ = fmt.Fprint(conn, "GET /ping HTTP/1.1\r\nHost: legacy.internal\r\n\r\n")
+	if resp, err := http.ReadResponse(reader, nil); err == nil {
+		safeClose(t, resp.Body)
+		t.Fatalf("expected the tunnel to be closed by Stop, got %d", resp.StatusCode)
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("expected the tunnel to be closed by Stop, but it stayed open")
+	}
+}
+
+func TestLifecycle_InMemory(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{InMemory: true})
+	defer ms.Close()
+	addPing(ms)
+	client := &http.Client{Transport: ms.Transport(), Timeout: 50 * time.Millisecond}
+
+	ms.Stop()
+	if _, err := client.Get(ms.URL() + "/ping"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("expected a refused error while stopped, got %v", err)
+	}
+	if err := ms.Pause(BlackHoleConnections); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Depending on which fires first, the client reports its own timeout or
+	// the context deadline; both are timeouts.
+	_, err := client.Get(ms.URL() + "/ping")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout while black-holing, got %v", err)
+	}
+	if err := ms.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status, _ := getWith(t, client, ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected 200 after Start, got %d", status)
+	}
+}
+
+func TestLifecycle_StartAfterClose(t *testing.T) {
+	ms := NewMockServer()
+	ms.Close()
+	if err := ms.Start(); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("expected Start after Close to fail, got %v", err)
+	}
+}
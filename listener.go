@@ -0,0 +1,47 @@
+package moxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// newListener returns the listener configured by Config.Listener,
+// Config.UnixSocket or Config.Address, or nil to let httptest pick a random
+// loopback port. It panics if more than one of them, or Config.InMemory, is
+// set, or if listening fails.
+func newListener(config *Config) net.Listener {
+	set := 0
+	for _, isSet := range []bool{config.Address != "", config.UnixSocket != "", config.Listener != nil, config.InMemory} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		panic("only one of Config.Address, Config.UnixSocket, Config.Listener and Config.InMemory may be set")
+	}
+	var listener net.Listener
+	var err error
+	switch {
+	case config.Listener != nil:
+		return config.Listener
+	case config.UnixSocket != "":
+		listener, err = net.Listen("unix", config.UnixSocket)
+	case config.Address != "":
+		listener, err = net.Listen("tcp", config.Address)
+	default:
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen: %v", err))
+	}
+	return listener
+}
+
+// listenerIPs returns the IP address listener is bound to, if any, for the
+// SANs of the generated server certificate.
+func listenerIPs(listener net.Listener) []net.IP {
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && addr.IP != nil {
+		return []net.IP{addr.IP}
+	}
+	return nil
+}
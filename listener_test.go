@@ -0,0 +1,179 @@
+package moxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// getWith sends a GET request with client and returns the status and body.
+func getWith(t *testing.T, client *http.Client, url string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// addPing registers GET /ping responding with "pong" and returns it.
+func addPing(ms *MockServer) *Expectation {
+	exp := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/ping").
+		AndRespondWithString("pong", 200)
+	ms.AddExpectation(exp)
+	return exp
+}
+
+func TestListener_FixedAddress(t *testing.T) {
+	// Reserve a free port, then release it for the server.
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr := probe.Addr().String()
+	_ = probe.Close()
+
+	ms := NewMockServerWithConfig(&Config{Address: addr})
+	defer ms.Close()
+	addPing(ms)
+
+	if ms.URL() != "http://"+addr {
+		t.Errorf("expected URL http://%s, got %s", addr, ms.URL())
+	}
+	if status, body := getWith(t, http.DefaultClient, "http://"+addr+"/ping"); status != http.StatusOK || body != "pong" {
+		t.Errorf("unexpected response: %d %q", status, body)
+	}
+}
+
+func TestListener_IPv6(t *testing.T) {
+	if probe, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	} else {
+		_ = probe.Close()
+	}
+	ms := NewMockServerWithConfig(&Config{Address: "[::1]:0", Protocol: HTTPS})
+	defer ms.Close()
+	addPing(ms)
+
+	if !strings.HasPrefix(ms.URL(), "https://[::1]:") {
+		t.Errorf("expected an IPv6 URL, got %s", ms.URL())
+	}
+	if status, _ := getWith(t, ms.Client(), ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected 200, got %d", status)
+	}
+	if record := ms.GetRecordedRequests()[0]; !strings.HasPrefix(record.Host, "[::1]:") {
+		t.Errorf("unexpected host %q", record.Host)
+	}
+}
+
+func TestListener_BoundAddressInCertificate(t *testing.T) {
+	// Any 127/8 address is a loopback address on Linux, but only 127.0.0.1 is
+	// in the certificate unless the bound address is added.
+	probe, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 unavailable: %v", err)
+	}
+	ms := NewMockServerWithConfig(&Config{Listener: probe, Protocol: HTTPS})
+	defer ms.Close()
+	addPing(ms)
+
+	if status, _ := getWith(t, ms.Client(), ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected 200, got %d", status)
+	}
+}
+
+func TestListener_UnixSocket(t *testing.T) {
+	// Socket paths are length-limited, so avoid the long t.TempDir paths.
+	dir, err := os.MkdirTemp("", "moxy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, protocol := range []Protocol{HTTP, HTTPS} {
+		socket := filepath.Join(dir, string(protocol)+".sock")
+		ms := NewMockServerWithConfig(&Config{UnixSocket: socket, Protocol: protocol})
+		addPing(ms)
+
+		if ms.URL() != string(protocol)+"://localhost" {
+			t.Errorf("%s: unexpected URL %s", protocol, ms.URL())
+		}
+		if status, body := getWith(t, ms.Client(), ms.URL()+"/ping"); status != http.StatusOK || body != "pong" {
+			t.Errorf("%s: unexpected response from Client: %d %q", protocol, status, body)
+		}
+		if status, _ := getWith(t, ms.DefaultClient(), ms.URL()+"/ping"); status != http.StatusOK {
+			t.Errorf("%s: unexpected response from DefaultClient: %d", protocol, status)
+		}
+		ms.Close()
+	}
+
+	// A daemon client dials the socket itself.
+	socket := filepath.Join(dir, "daemon.sock")
+	ms := NewMockServerWithConfig(&Config{UnixSocket: socket})
+	defer ms.Close()
+	addPing(ms)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	if status, _ := getWith(t, client, "http://docker/ping"); status != http.StatusOK {
+		t.Errorf("expected 200, got %d", status)
+	}
+}
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestListener_CustomListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	listener := &countingListener{Listener: inner}
+	ms := NewMockServerWithConfig(&Config{Listener: listener})
+	addPing(ms)
+
+	if status, _ := getWith(t, ms.Client(), ms.URL()+"/ping"); status != http.StatusOK {
+		t.Errorf("expected 200, got %d", status)
+	}
+	if listener.accepted.Load() != 1 {
+		t.Errorf("expected the custom listener to accept the connection, got %d", listener.accepted.Load())
+	}
+	ms.Close()
+	if _, err := net.Dial("tcp", inner.Addr().String()); err == nil {
+		t.Error("expected Close to close the custom listener")
+	}
+}
+
+func TestListener_ConflictingOptionsPanic(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "only one of Config.Address") {
+			t.Errorf("expected panic for conflicting listener options, got %v", r)
+		}
+	}()
+	NewMockServerWithConfig(&Config{Address: "127.0.0.1:0", InMemory: true})
+}
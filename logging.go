@@ -0,0 +1,142 @@
+package moxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// redacted replaces redacted header values and body fragments in log events.
+const redacted = "[REDACTED]"
+
+// defaultMaxLoggedBody is the default LogOptions.MaxBodyBytes.
+const defaultMaxLoggedBody = 1024
+
+// DefaultRedactedHeaders are the headers redacted when
+// LogOptions.RedactHeaders is nil, and in the request logs of LogUnmatched and
+// VerboseLogging.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// WithSlogHandler sends structured log events to handler instead of the
+// free-form request logs of LogUnmatched and VerboseLogging:
+//   - request.received (debug): method, url, host, remote_addr, headers, body
+//   - expectation.matched (info): method, url, expectation
+//   - request.unmatched (warn): method, url, headers, body, mismatches
+//   - response.sent (debug): method, url, status, matched, duration
+//
+// Header values and body fragments are redacted and bodies truncated as
+// configured by opts; nil opts uses the defaults.
+// Example: ms.WithSlogHandler(slog.NewJSONHandler(os.Stderr, nil), &LogOptions{MaxBodyBytes: 256})
+func (m *MockServer) WithSlogHandler(handler slog.Handler, opts *LogOptions) *MockServer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slogger = slog.New(handler)
+	m.logOptions = LogOptions{}
+	if opts != nil {
+		m.logOptions = *opts
+	}
+	if m.logOptions.RedactHeaders == nil {
+		m.logOptions.RedactHeaders = DefaultRedactedHeaders
+	}
+	if m.logOptions.MaxBodyBytes == 0 {
+		m.logOptions.MaxBodyBytes = defaultMaxLoggedBody
+	}
+	return m
+}
+
+// logEvent emits a structured event if WithSlogHandler is set.
+func (m *MockServer) logEvent(r *http.Request, level slog.Level, event string, attrs ...slog.Attr) {
+	if m.slogger == nil {
+		return
+	}
+	attrs = append([]slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("url", r.URL.RequestURI()),
+	}, attrs...)
+	m.slogger.LogAttrs(context.Background(), level, event, attrs...)
+}
+
+// logRequestReceived emits request.received.
+func (m *MockServer) logRequestReceived(r *http.Request, body []byte) {
+	if m.slogger == nil {
+		return
+	}
+	m.logEvent(r, slog.LevelDebug, "request.received",
+		slog.String("host", r.Host),
+		slog.String("remote_addr", r.RemoteAddr),
+		m.headersAttr(r.Header),
+		m.bodyAttr(body))
+}
+
+// logUnmatched emits request.unmatched.
+func (m *MockServer) logUnmatched(r *http.Request, body []byte, mismatches []string) {
+	if m.slogger == nil {
+		return
+	}
+	m.logEvent(r, slog.LevelWarn, "request.unmatched",
+		m.headersAttr(r.Header),
+		m.bodyAttr(body),
+		slog.Any("mismatches", mismatches))
+}
+
+// logResponseSent emits response.sent.
+func (m *MockServer) logResponseSent(r *http.Request, status int, matched bool, duration time.Duration) {
+	m.logEvent(r, slog.LevelDebug, "response.sent",
+		slog.Int("status", status),
+		slog.Bool("matched", matched),
+		slog.Duration("duration", duration))
+}
+
+// headersAttr groups the headers, sorted by name, with redacted values.
+func (m *MockServer) headersAttr(header http.Header) slog.Attr {
+	attrs := make([]any, 0, len(header))
+	for _, key := range sortedKeys(header) {
+		value := strings.Join(header[key], ", ")
+		if m.isRedactedHeader(key) {
+			value = redacted
+		}
+		attrs = append(attrs, slog.String(key, value))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// redactHeaders returns a copy of header with redacted values, for the
+// free-form request logs.
+func (m *MockServer) redactHeaders(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for key, values := range header {
+		if m.isRedactedHeader(key) {
+			values = []string{redacted}
+		}
+		result[key] = values
+	}
+	return result
+}
+
+// isRedactedHeader reports whether the value of the header must not be logged.
+func (m *MockServer) isRedactedHeader(key string) bool {
+	names := m.logOptions.RedactHeaders
+	if names == nil {
+		names = DefaultRedactedHeaders
+	}
+	for _, name := range names {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyAttr returns the body with RedactBody matches replaced, truncated to
+// MaxBodyBytes, or an empty attribute if bodies are not logged.
+func (m *MockServer) bodyAttr(body []byte) slog.Attr {
+	if m.logOptions.MaxBodyBytes < 0 || len(body) == 0 {
+		return slog.Attr{}
+	}
+	for _, pattern := range m.logOptions.RedactBody {
+		body = pattern.ReplaceAll(body, []byte(redacted))
+	}
+	return slog.String("body", truncate(body, m.logOptions.MaxBodyBytes))
+}
@@ -0,0 +1,193 @@
+package moxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects JSON log events emitted through a slog.JSONHandler.
+type eventRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *eventRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *eventRecorder) events(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(r.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// waitForEvents returns the events once at least n have been emitted;
+// response.sent may be logged after the client has read the response.
+func (r *eventRecorder) waitForEvents(t *testing.T, n int) []map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		events := r.events(t)
+		if len(events) >= n || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newRecordingHandler() (*eventRecorder, slog.Handler) {
+	rec := &eventRecorder{}
+	return rec, slog.NewJSONHandler(rec, &slog.HandlerOptions{Level: slog.LevelDebug})
+}
+
+func TestSlog_Events(t *testing.T) {
+	var legacy bytes.Buffer
+	rec, handler := newRecordingHandler()
+	ms := NewMockServerWithConfig(&Config{LogUnmatched: true, VerboseLogging: true})
+	defer ms.Close()
+	ms.WithLogger(log.New(&legacy, "", 0)).WithSlogHandler(handler, nil)
+	addPing(ms)
+
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	req, _ := http.NewRequest("POST", ms.URL()+"/missing?x=1", strings.NewReader("payload"))
+	req.Header.Set("Authorization", "Bearer secret-token")
+	resp, err := ms.Client().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	var names []string
+	byName := map[string]map[string]interface{}{}
+	for _, event := range rec.waitForEvents(t, 6) {
+		name := event["msg"].(string)
+		names = append(names, name)
+		byName[name+" "+event["url"].(string)] = event
+	}
+	want := []string{
+		"request.received", "expectation.matched", "response.sent",
+		"request.received", "request.unmatched", "response.sent",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected events %v, got %v", want, names)
+	}
+
+	matched := byName["expectation.matched /ping"]
+	if matched["level"] != "INFO" || matched["method"] != "GET" || !strings.HasPrefix(matched["expectation"].(string), "GET ^/ping$ (called: 1") {
+		t.Errorf("unexpected expectation.matched event: %v", matched)
+	}
+	unmatched := byName["request.unmatched /missing?x=1"]
+	if unmatched["level"] != "WARN" || unmatched["body"] != "payload" {
+		t.Errorf("unexpected request.unmatched event: %v", unmatched)
+	}
+	if headers := unmatched["headers"].(map[string]interface{}); headers["Authorization"] != "[REDACTED]" {
+		t.Errorf("expected Authorization to be redacted, got %v", headers["Authorization"])
+	}
+	if mismatches := unmatched["mismatches"].([]interface{}); len(mismatches) != 1 {
+		t.Errorf("expected one mismatch, got %v", mismatches)
+	}
+	sent := byName["response.sent /missing?x=1"]
+	if sent["status"] != float64(http.StatusTeapot) || sent["matched"] != false || sent["duration"] == nil {
+		t.Errorf("unexpected response.sent event: %v", sent)
+	}
+	received := byName["request.received /ping"]
+	if received["level"] != "DEBUG" || received["host"] == "" || received["remote_addr"] == "" {
+		t.Errorf("unexpected request.received event: %v", received)
+	}
+
+	if legacy.Len() != 0 {
+		t.Errorf("expected structured events to replace the request logs, got:\n%s", legacy.String())
+	}
+}
+
+func TestSlog_RedactionAndTruncation(t *testing.T) {
+	rec, handler := newRecordingHandler()
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.WithSlogHandler(handler, &LogOptions{
+		RedactHeaders: []string{"x-session"},
+		RedactBody:    []*regexp.Regexp{regexp.MustCompile(`"password":\s*"[^"]*"`)},
+		MaxBodyBytes:  40,
+	})
+
+	req, _ := http.NewRequest("POST", ms.URL()+"/login",
+		strings.NewReader(`{"user":"alice","password": "hunter2","remember":true,"device":"laptop"}`))
+	req.Header.Set("X-Session", "abc")
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	resp, err := ms.Client().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	event := rec.waitForEvents(t, 3)[0]
+	headers := event["headers"].(map[string]interface{})
+	if headers["X-Session"] != "[REDACTED]" {
+		t.Errorf("expected X-Session to be redacted, got %v", headers["X-Session"])
+	}
+	if headers["Authorization"] != "Basic dXNlcjpwYXNz" {
+		t.Errorf("expected only configured headers to be redacted, got %v", headers["Authorization"])
+	}
+	if body := event["body"]; body != `{"user":"alice",[REDACTED],"remember":tr...` {
+		t.Errorf("expected a redacted, truncated body, got %v", body)
+	}
+
+	ms.WithSlogHandler(handler, &LogOptions{MaxBodyBytes: -1})
+	resp, err = ms.Client().Post(ms.URL()+"/login", "text/plain", strings.NewReader("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	for _, event := range rec.waitForEvents(t, 6)[3:] {
+		if _, ok := event["body"]; ok {
+			t.Errorf("expected bodies to be omitted, got %v", event)
+		}
+	}
+}
+
+func TestLegacyLogging_RedactsHeaders(t *testing.T) {
+	var legacy bytes.Buffer
+	ms := NewMockServerWithConfig(&Config{LogUnmatched: true, VerboseLogging: true})
+	defer ms.Close()
+	ms.WithLogger(log.New(&legacy, "", 0))
+
+	req, _ := http.NewRequest("GET", ms.URL()+"/missing", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Cookie", "session=secret-session")
+	req.Header.Set("X-Trace", "visible")
+	resp, err := ms.Client().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	logs := legacy.String()
+	if strings.Contains(logs, "secret-token") || strings.Contains(logs, "secret-session") {
+		t.Errorf("expected sensitive headers to be redacted, got:\n%s", logs)
+	}
+	if strings.Count(logs, "[REDACTED]") != 4 || !strings.Contains(logs, "visible") {
+		t.Errorf("expected redacted and visible headers in both logs, got:\n%s", logs)
+	}
+}
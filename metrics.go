@@ -0,0 +1,213 @@
+package moxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsContextKey carries the *requestObservation of a request.
+type metricsContextKey struct{}
+
+// durationBuckets are the upper bounds of the request duration histogram in
+// seconds, the Prometheus client defaults.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// serverMetrics holds the counters behind MetricsHandler. Invocations per
+// expectation come from Expectation.InvocationCount. Guarded by MockServer.mu.
+type serverMetrics struct {
+	requests  uint64
+	unmatched uint64
+	proxied   uint64
+	responses map[int]uint64
+	durations map[bool]*histogram // keyed by whether an expectation matched
+}
+
+// histogram is a Prometheus histogram over durationBuckets.
+type histogram struct {
+	buckets []uint64 // non-cumulative count per bucket, the last one is +Inf
+	sum     float64
+	count   uint64
+}
+
+// requestObservation collects what the handler learns about a request for
+// the metrics recorded once it completes.
+type requestObservation struct {
+	matched bool
+}
+
+// statusRecorder captures the status code written by the handler chain.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// MetricsHandler returns a handler serving the server's metrics in the
+// Prometheus text exposition format: requests per expectation, unmatched and
+// proxied requests, response status codes and a request duration histogram.
+// Requests abandoned by the client before a response was written, such as
+// those matching a SimulateTimeout expectation, are reported with code "0".
+// Set Config.MetricsPath to serve it from the mock server itself.
+// Example: http.Handle("/metrics", ms.MetricsHandler())
+func (m *MockServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writeMetrics(w)
+	})
+}
+
+// isMetricsRequest reports whether r should be answered by MetricsHandler.
+func (m *MockServer) isMetricsRequest(r *http.Request) bool {
+	return m.config.MetricsPath != "" && r.Method == http.MethodGet &&
+		!r.URL.IsAbs() && r.URL.Path == m.config.MetricsPath
+}
+
+// observeRequest serves r with next, records its metrics and emits
+// response.sent.
+func (m *MockServer) observeRequest(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	start := time.Now()
+	obs := &requestObservation{}
+	rec := &statusRecorder{ResponseWriter: w}
+	next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), metricsContextKey{}, obs)))
+
+	status := rec.status
+	if status == 0 {
+		switch {
+		case r.Method == http.MethodConnect:
+			return // the connection was hijacked for a tunnel
+		case r.Context().Err() == nil:
+			status = http.StatusOK
+		}
+		// Otherwise the client gave up before a response was written, e.g. on
+		// a SimulateTimeout expectation; it is counted with status code 0.
+	}
+	duration := time.Since(start)
+	m.logResponseSent(r, status, obs.matched, duration)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metrics := &m.metrics
+	metrics.requests++
+	if metrics.responses == nil {
+		metrics.responses = make(map[int]uint64)
+		metrics.durations = make(map[bool]*histogram)
+	}
+	metrics.responses[status]++
+	h, ok := metrics.durations[obs.matched]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets)+1)}
+		metrics.durations[obs.matched] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// markMatched records in the observation of r that an expectation matched.
+func markMatched(r *http.Request) {
+	if obs, ok := r.Context().Value(metricsContextKey{}).(*requestObservation); ok {
+		obs.matched = true
+	}
+}
+
+func (h *histogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	h.buckets[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// writeMetrics writes all metrics in the Prometheus text format.
+func (m *MockServer) writeMetrics(w io.Writer) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var sb strings.Builder
+	metrics := &m.metrics
+
+	writeMetricHeader(&sb, "moxy_requests_total", "counter", "Requests served by the mock server.")
+	fmt.Fprintf(&sb, "moxy_requests_total %d\n", metrics.requests)
+
+	writeMetricHeader(&sb, "moxy_expectation_invocations_total", "counter", "Requests matched per expectation.")
+	writeInvocations := func(host string, expectations []*Expectation) {
+		for i, exp := range expectations {
+			path := exp.Request.Path
+			if exp.Request.PathPattern != nil {
+				path = exp.Request.PathPattern.String()
+			}
+			fmt.Fprintf(&sb, "moxy_expectation_invocations_total{host=%s,index=\"%d\",method=%s,path=%s} %d\n",
+				quoteLabel(host), i, quoteLabel(exp.Request.Method), quoteLabel(path), exp.InvocationCount)
+		}
+	}
+	writeInvocations("", m.expectations)
+	for _, hostname := range sortedKeys(m.virtualHosts) {
+		writeInvocations(hostname, m.virtualHosts[hostname].expectations)
+	}
+
+	writeMetricHeader(&sb, "moxy_unmatched_requests_total", "counter", "Requests that matched no expectation and were not proxied.")
+	fmt.Fprintf(&sb, "moxy_unmatched_requests_total %d\n", metrics.unmatched)
+
+	writeMetricHeader(&sb, "moxy_proxied_requests_total", "counter", "Unmatched requests forwarded upstream.")
+	fmt.Fprintf(&sb, "moxy_proxied_requests_total %d\n", metrics.proxied)
+
+	writeMetricHeader(&sb, "moxy_responses_total", "counter", "Responses by status code.")
+	codes := make([]int, 0, len(metrics.responses))
+	for code := range metrics.responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&sb, "moxy_responses_total{code=\"%d\"} %d\n", code, metrics.responses[code])
+	}
+
+	writeMetricHeader(&sb, "moxy_request_duration_seconds", "histogram", "Time to serve requests, including simulated delays.")
+	for _, matched := range []bool{true, false} {
+		h, ok := metrics.durations[matched]
+		if !ok {
+			continue
+		}
+		label := fmt.Sprintf("matched=\"%t\"", matched)
+		var cumulative uint64
+		for i, count := range h.buckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(durationBuckets) {
+				le = strconv.FormatFloat(durationBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(&sb, "moxy_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", label, le, cumulative)
+		}
+		fmt.Fprintf(&sb, "moxy_request_duration_seconds_sum{%s} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&sb, "moxy_request_duration_seconds_count{%s} %d\n", label, h.count)
+	}
+	_, _ = io.WriteString(w, sb.String())
+}
+
+func writeMetricHeader(sb *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// quoteLabel quotes a label value, escaping backslashes, quotes and newlines.
+func quoteLabel(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(value) + `"`
+}
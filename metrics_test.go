@@ -0,0 +1,151 @@
+package moxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Endpoint(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{MetricsPath: "/metrics"})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/users").
+		AndRespondWithString("[]", 200))
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/users/{id}").
+		WithResponseDelay(30*time.Millisecond).
+		AndRespondWithString("created", 201))
+	ms.AddVirtualHost("api.partner.com", nil).AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath(`/say "hi"`).
+		AndRespondWithString("hi", 200))
+
+	getWith(t, ms.Client(), ms.URL()+"/users")
+	getWith(t, ms.Client(), ms.URL()+"/users")
+	resp, err := ms.Client().Post(ms.URL()+"/users/1", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	getWith(t, ms.Client(), ms.URL()+"/missing")
+
+	resp, err = ms.Client().Get(ms.URL() + "/metrics")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	metrics := string(body)
+	for _, line := range []string{
+		"# TYPE moxy_requests_total counter",
+		"moxy_requests_total 4",
+		`moxy_expectation_invocations_total{host="",index="0",method="GET",path="^/users$"} 2`,
+		`moxy_expectation_invocations_total{host="",index="1",method="POST",path="^/users/(?P<id>[^/]+)$"} 1`,
+		`moxy_expectation_invocations_total{host="api.partner.com",index="0",method="GET",path="^/say \"hi\"$"} 0`,
+		"moxy_unmatched_requests_total 1",
+		"moxy_proxied_requests_total 0",
+		`moxy_responses_total{code="200"} 2`,
+		`moxy_responses_total{code="201"} 1`,
+		`moxy_responses_total{code="418"} 1`,
+		"# TYPE moxy_request_duration_seconds histogram",
+		`moxy_request_duration_seconds_bucket{matched="true",le="0.025"} 2`,
+		`moxy_request_duration_seconds_bucket{matched="true",le="+Inf"} 3`,
+		`moxy_request_duration_seconds_count{matched="true"} 3`,
+		`moxy_request_duration_seconds_count{matched="false"} 1`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, metrics)
+		}
+	}
+	if n := len(ms.GetRecordedRequests()); n != 4 {
+		t.Errorf("expected metrics scrapes not to be journaled, got %d requests", n)
+	}
+}
+
+func TestMetrics_DisabledByDefault(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+
+	if status, _ := getWith(t, ms.Client(), ms.URL()+"/metrics"); status != http.StatusTeapot {
+		t.Errorf("expected /metrics to be an unmatched request by default, got %d", status)
+	}
+
+	rec := httptest.NewRecorder()
+	ms.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "moxy_unmatched_requests_total 1\n") {
+		t.Errorf("expected MetricsHandler to report the unmatched request, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetrics_ProxiedRequests(t *testing.T) {
+	upstream := NewMockServer()
+	defer upstream.Close()
+	upstream.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/real").
+		AndRespondWithString("real", 200))
+	cfg := &Config{MetricsPath: "/metrics"}
+	cfg.ProxyUnmatchedTo(upstream.URL())
+	ms := NewMockServerWithConfig(cfg)
+	defer ms.Close()
+
+	getWith(t, ms.Client(), ms.URL()+"/real")
+	_, metrics := getWith(t, ms.Client(), ms.URL()+"/metrics")
+	for _, line := range []string{"moxy_proxied_requests_total 1", "moxy_unmatched_requests_total 0", `moxy_responses_total{code="200"} 1`} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, metrics)
+		}
+	}
+}
+
+func TestMetrics_AbandonedRequests(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{MetricsPath: "/metrics"})
+	defer ms.Close()
+	exp := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/slow").
+		SimulateTimeout()
+	ms.AddExpectation(exp)
+
+	client := ms.Client()
+	client.Timeout = 50 * time.Millisecond
+	if _, err := client.Get(ms.URL() + "/slow"); err == nil {
+		t.Fatal("expected the client to time out")
+	}
+
+	want := []string{
+		"moxy_requests_total 1",
+		`moxy_expectation_invocations_total{host="",index="0",method="GET",path="^/slow$"} 1`,
+		`moxy_responses_total{code="0"} 1`,
+		`moxy_request_duration_seconds_count{matched="true"} 1`,
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		// The server notices the cancellation after the client has returned.
+		_, metrics := getWith(t, ms.Client(), ms.URL()+"/metrics")
+		missing := ""
+		for _, line := range want {
+			if !strings.Contains(metrics, line+"\n") {
+				missing = line
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", missing, metrics)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
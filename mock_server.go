@@ -1,14 +1,18 @@
 package moxy
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -51,27 +55,78 @@ func NewMockServer() *MockServer {
 func NewMockServerWithConfig(customConfig *Config) *MockServer {
 	config := mergeWithDefaults(customConfig)
 	ms := &MockServer{
-		logger: log.New(os.Stdout, "[MockServer] ", log.LstdFlags|log.Lshortfile),
-		config: *config,
+		logger:        log.New(os.Stdout, "[MockServer] ", log.LstdFlags|log.Lshortfile),
+		config:        *config,
+		cookieSession: make(cookieSession),
 	}
 
+	if config.ProxyUnmatched != nil {
+		ms.proxy = ms.newReverseProxy(config.ProxyUnmatched)
+	}
+
+	listener := newListener(config)
+	var server *httptest.Server
+	switch {
+	case config.InMemory:
+	case listener != nil:
+		server = &httptest.Server{Listener: listener, Config: &http.Server{Handler: http.HandlerFunc(ms.serveHTTP)}}
+	default:
+		server = httptest.NewUnstartedServer(http.HandlerFunc(ms.serveHTTP))
+	}
+
+	// Tunneled TLS in forward proxy mode reuses the server's TLS setup.
+	if config.Protocol == HTTPS || config.ForwardProxy != nil {
+		var ips []net.IP
+		if server != nil {
+			ips = listenerIPs(server.Listener)
+		}
+		tlsConfig := buildTLSConfig(config.TLSConfig, ips)
+		ms.certificates = tlsConfig.Certificates
+		ms.clientCAs = tlsConfig.ClientCAs
+		if tlsConfig.NextProtos == nil {
+			tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+		tlsConfig.GetCertificate = ms.selectCertificate
+		ms.tlsConfig = tlsConfig
+	}
+
+	ms.handlerChain = http.HandlerFunc(ms.handler)
+	if config.ForwardProxy != nil {
+		if config.ForwardProxy.InterceptTLS {
+			ms.proxyCA = interceptionCA(config.ForwardProxy)
+		}
+		ms.startTunnelServer()
+	}
+	if config.InMemory {
+		ms.listener = &pausableListener{}
+		return ms
+	}
+	ms.listener = newPausableListener(server.Listener)
+	server.Listener = ms.listener
+	ms.configureConnections(server.Config)
 	if config.Protocol == HTTPS {
-		server := httptest.NewUnstartedServer(http.HandlerFunc(ms.handler))
-		server.TLS = buildTLSConfig(config.TLSConfig)
+		server.EnableHTTP2 = containsString(ms.tlsConfig.NextProtos, "h2")
+		server.TLS = ms.tlsConfig.Clone()
+		server.TLS.GetConfigForClient = ms.configForClient
 		server.StartTLS()
-		ms.server = server
 	} else {
-		ms.server = httptest.NewServer(http.HandlerFunc(ms.handler))
+		server.Start()
+	}
+	if server.Listener.Addr().Network() == "unix" {
+		// A socket path is not a URL host; clients dial the socket instead.
+		server.URL = strings.SplitN(server.URL, "://", 2)[0] + "://localhost"
 	}
+	ms.server = server
 	return ms
 }
 
-// buildTLSConfig builds a *tls.Config from TLSOptions.
-func buildTLSConfig(opts *TLSOptions) *tls.Config {
+// buildTLSConfig builds a *tls.Config from TLSOptions. A generated default
+// certificate is also valid for ips, the addresses the server listens on.
+func buildTLSConfig(opts *TLSOptions, ips []net.IP) *tls.Config {
 	tlsConfig := &tls.Config{}
 
 	if opts == nil {
-		tlsConfig.Certificates = []tls.Certificate{generateDefaultCert()}
+		tlsConfig.Certificates = []tls.Certificate{generateDefaultCert(ips...)}
 		tlsConfig.InsecureSkipVerify = true
 		return tlsConfig
 	}
@@ -80,11 +135,16 @@ func buildTLSConfig(opts *TLSOptions) *tls.Config {
 	} else {
 		tlsConfig.MinVersion = tls.VersionTLS12 // default
 	}
+	tlsConfig.MaxVersion = opts.MaxVersion
+	tlsConfig.CipherSuites = opts.CipherSuites
+	tlsConfig.CurvePreferences = opts.CurvePreferences
+	tlsConfig.NextProtos = opts.NextProtos
+	tlsConfig.SessionTicketsDisabled = opts.SessionTicketsDisabled
 	// Server certs
 	if len(opts.Certificates) > 0 {
 		tlsConfig.Certificates = opts.Certificates
 	} else {
-		tlsConfig.Certificates = []tls.Certificate{generateDefaultCert()}
+		tlsConfig.Certificates = []tls.Certificate{generateDefaultCert(ips...)}
 	}
 	// mTLS configuration
 	if opts.RequireClientCert {
@@ -118,13 +178,28 @@ func (m *MockServer) WithUnmatchedResponder(
 	return m
 }
 
-// Close shuts down the mock server.
+// Close shuts down the mock server for good. Use Stop to take it down
+// temporarily.
 func (m *MockServer) Close() {
-	m.server.Close()
+	if m.server != nil {
+		m.server.Close()
+	}
+	if m.tunnelServer != nil {
+		_ = m.tunnelServer.Close()
+	}
 }
 
-// URL returns the base URL of the mock server.
+// URL returns the base URL of the mock server. With Config.InMemory it is a
+// placeholder URL that is only reachable through Transport; with
+// Config.UnixSocket its host is localhost and clients must dial the socket
+// (Client and DefaultClient do).
 func (m *MockServer) URL() string {
+	if m.server == nil {
+		if m.config.Protocol == HTTPS {
+			return "https://" + inMemoryHost
+		}
+		return "http://" + inMemoryHost
+	}
 	return m.server.URL
 }
 
@@ -171,13 +246,33 @@ func (m *MockServer) ClearUnmatchedRequests() {
 	m.unmatchedRequests = m.unmatchedRequests[:0]
 }
 
+// GetRecordedRequests returns a copy of the request journal: every request
+// received by the server, matched or not, in arrival order.
+func (m *MockServer) GetRecordedRequests() []RecordedRequest {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make([]RecordedRequest, len(m.requests))
+	for i, record := range m.requests {
+		result[i] = record.snapshot()
+	}
+	return result
+}
+
+// ClearRecordedRequests clears the request journal.
+func (m *MockServer) ClearRecordedRequests() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = m.requests[:0]
+	m.journalResets++
+}
+
 // VerifyExpectations checks if all expectations were called the expected number of times.
 func (m *MockServer) VerifyExpectations() error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var unmet []string
-	for _, exp := range m.expectations {
+	for _, exp := range m.allExpectations() {
 		if exp.MaxCalls != nil && exp.InvocationCount != *exp.MaxCalls {
 			unmet = append(unmet, exp.String())
 		}
@@ -194,6 +289,9 @@ func (m *MockServer) VerifyExpectations() error {
 
 // handler processes incoming HTTP requests and returns the configured mock response.
 func (m *MockServer) handler(w http.ResponseWriter, r *http.Request) {
+	if m.handleProxyRequest(w, r) {
+		return
+	}
 	var body []byte
 	var err error
 	if r.Body != nil {
@@ -208,121 +306,303 @@ func (m *MockServer) handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if m.config.VerboseLogging {
+	if m.slogger == nil && m.config.VerboseLogging {
 		m.logger.Printf("Incoming request: %s %s, Headers: %+v, Body: %s",
-			r.Method, r.URL.String(), r.Header, string(body))
+			r.Method, r.URL.String(), m.redactHeaders(r.Header), string(body))
 	}
+	m.logRequestReceived(r, body)
+	record := RecordedRequest{
+		Method:     r.Method,
+		URL:        r.URL.RequestURI(),
+		Host:       r.Host,
+		Headers:    map[string][]string(r.Header),
+		Body:       string(body),
+		Timestamp:  time.Now(),
+		Proxy:      proxyDetails(r),
+		Connection: connectionDetails(r),
+	}
+	if form := parseForm(r, body); form != nil {
+		record.Form = form.Values
+		record.Files = form.Files
+	}
+
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	for _, exp := range m.expectations {
-		if exp.matches(r, body) {
-			if exp.MaxCalls != nil && exp.InvocationCount >= *exp.MaxCalls {
-				continue
-			}
-			exp.InvocationCount++
-			resp := ResponseDefinition{}
-			// If user configured responses, pick the right one
-			if len(exp.Responses) > 0 {
-				resp = exp.Responses[exp.NextResponseIndex]
-				if exp.NextResponseIndex < len(exp.Responses)-1 {
-					exp.NextResponseIndex++
-				}
-			}
-			if resp.TimeoutSimulation {
-				<-r.Context().Done() // blocks until the request is canceled by the client
-				return
-			}
-			// Simulate delayed response.
-			if resp.Delay > 0 {
-				time.Sleep(resp.Delay)
-			}
-			// Write headers
-			for key, value := range resp.Headers {
-				w.Header().Set(key, value)
-			}
-			w.WriteHeader(resp.StatusCode)
-			if _, err := w.Write(resp.Body); err != nil {
-				m.logger.Printf("Failed to write response: %v", err)
-			}
-			if m.config.VerboseLogging {
-				m.logger.Printf("Matched expectation, responding with status %d", resp.StatusCode)
-			}
-			return
+	exp, resp, matched, mismatches := m.selectExpectation(withCookieSession(r, m.cookieSession), body)
+	record.Matched = matched
+	record.Expectation = exp
+	record.TLS = m.tlsDetails(r)
+	if !matched && m.proxy != nil {
+		m.mu.Unlock()
+		m.proxyUnmatched(w, r, body, record)
+		return
+	}
+	m.journal(record)
+	if matched {
+		markMatched(r)
+		if !resp.TimeoutSimulation {
+			m.cookieSession.record(resp.Cookies, time.Now())
+		}
+		var expectation string
+		if m.slogger != nil {
+			expectation = exp.String()
 		}
+		m.mu.Unlock()
+		m.logEvent(r, slog.LevelInfo, "expectation.matched", slog.String("expectation", expectation))
+		m.runHooks(record, nil)
+		m.writeResponse(w, r, resp)
+		return
 	}
 
 	// No match -> record unmatched
 	unmatched := UnmatchedRequest{
-		Method:    r.Method,
-		URL:       r.URL.RequestURI(),
-		Headers:   map[string][]string(r.Header),
-		Body:      string(body),
-		Timestamp: time.Now(),
+		Method:     record.Method,
+		URL:        record.URL,
+		Headers:    record.Headers,
+		Body:       record.Body,
+		Timestamp:  record.Timestamp,
+		Mismatches: mismatches,
 	}
 	m.unmatchedRequests = append(m.unmatchedRequests, unmatched)
+	m.metrics.unmatched++
+	unmatchedResponder := m.unmatchedResponder
+	m.mu.Unlock()
 
-	if m.config.LogUnmatched {
-		m.logger.Printf("Unexpected Request:\nMethod=%s\nURI=%s\nHeaders=%+v\nBody=%s\n",
-			r.Method, r.URL.RequestURI(), r.Header, string(body))
+	if m.slogger != nil {
+		m.logUnmatched(r, body, mismatches)
+	} else if m.config.LogUnmatched {
+		m.logger.Printf("Unexpected Request:\nMethod=%s\nURI=%s\nHeaders=%+v\nBody=%s\n%s",
+			r.Method, r.URL.RequestURI(), m.redactHeaders(r.Header), string(body), formatMismatches(mismatches))
 	}
+	m.runHooks(record, &unmatched)
 
-	if m.unmatchedResponder != nil {
-		m.unmatchedResponder(w, r, unmatched)
+	if unmatchedResponder != nil {
+		unmatchedResponder(w, r, unmatched)
 		return
 	}
-	_ = fmt.Sprintf("Unmatched Request:\nMethod=%s\nURI=%s\nHeaders=%+v\nBody=%s\n", r.Method, r.URL.RequestURI(), r.Header, string(body))
 	http.Error(w, m.config.UnmatchedStatusMessage, m.config.UnmatchedStatusCode)
 }
 
+// selectExpectation finds the best expectation matching the request that has
+// calls left, counts the invocation and picks the response to send. The best
+// match has the highest priority, then, with Config.MatchMostSpecific, the
+// highest specificity; remaining ties go to the first registered.
+// If nothing matches, it explains why each candidate expectation was rejected.
+// Callers must hold m.mu.
+func (m *MockServer) selectExpectation(r *http.Request, body []byte) (*Expectation, ResponseDefinition, bool, []string) {
+	var mismatches []string
+	var best *Expectation
+	for _, exp := range m.expectationsFor(r) {
+		if reason := exp.mismatch(r, body); reason != "" {
+			mismatches = append(mismatches, exp.String()+": "+reason)
+			continue
+		}
+		if exp.MaxCalls != nil && exp.InvocationCount >= *exp.MaxCalls {
+			mismatches = append(mismatches, fmt.Sprintf("%s: call limit of %d reached", exp, *exp.MaxCalls))
+			continue
+		}
+		if best == nil || m.preferred(exp, best) {
+			best = exp
+		}
+	}
+	if best == nil {
+		return nil, ResponseDefinition{}, false, mismatches
+	}
+	best.InvocationCount++
+	resp := ResponseDefinition{}
+	// If user configured responses, pick the right one
+	if len(best.Responses) > 0 {
+		resp = best.Responses[best.NextResponseIndex]
+		if best.NextResponseIndex < len(best.Responses)-1 {
+			best.NextResponseIndex++
+		}
+	}
+	return best, resp, true, nil
+}
+
+// preferred reports whether candidate should be chosen over current, an
+// earlier registered match.
+func (m *MockServer) preferred(candidate, current *Expectation) bool {
+	if candidate.Priority != current.Priority {
+		return candidate.Priority > current.Priority
+	}
+	if !m.config.MatchMostSpecific {
+		return false
+	}
+	candidatePath, candidateConstraints := candidate.Request.specificity()
+	currentPath, currentConstraints := current.Request.specificity()
+	if candidatePath != currentPath {
+		return candidatePath > currentPath
+	}
+	return candidateConstraints > currentConstraints
+}
+
+// formatMismatches renders mismatch reasons for the unmatched request log.
+func formatMismatches(mismatches []string) string {
+	if len(mismatches) == 0 {
+		return "Mismatches: no expectations registered\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("Mismatches:\n")
+	for _, mismatch := range mismatches {
+		sb.WriteString("  - " + mismatch + "\n")
+	}
+	return sb.String()
+}
+
+// writeResponse writes a matched response. It is called without holding m.mu
+// so that delayed or timed out responses do not block other requests.
+func (m *MockServer) writeResponse(w http.ResponseWriter, r *http.Request, resp ResponseDefinition) {
+	if resp.TimeoutSimulation {
+		<-r.Context().Done() // blocks until the request is canceled by the client
+		return
+	}
+	// Simulate delayed response.
+	if resp.Delay > 0 {
+		time.Sleep(resp.Delay)
+	}
+	// Write headers
+	for key, value := range resp.Headers {
+		w.Header().Set(key, value)
+	}
+	for key, values := range resp.HeaderValues {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	for _, cookie := range resp.Cookies {
+		http.SetCookie(w, cookie)
+	}
+	// Announce trailers before the header is written
+	for _, key := range sortedKeys(resp.Trailers) {
+		w.Header().Add("Trailer", key)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := w.Write(resp.Body); err != nil {
+		m.logger.Printf("Failed to write response: %v", err)
+	}
+	for key, values := range resp.Trailers {
+		w.Header()[key] = values
+	}
+	if m.slogger == nil && m.config.VerboseLogging {
+		m.logger.Printf("Matched expectation, responding with status %d", resp.StatusCode)
+	}
+}
+
 // DefaultClient returns a simple *http.Client for HTTP/HTTPS testing.
 // This client:
 //   - Works for HTTP
-//   - Works for HTTPS with server certs if InsecureSkipVerify is true
-//   - DOES NOT handle mTLS; for that, create a custom client with TLS config
+//   - Works for HTTPS by skipping server certificate verification (InsecureSkipVerify)
+//   - DOES NOT handle mTLS; use ClientWithCert for that
+//
+// Prefer Client, which verifies the server certificate like a production client would.
 func (m *MockServer) DefaultClient() *http.Client {
+	if m.server == nil {
+		return &http.Client{Transport: m.Transport()}
+	}
 	transport := &http.Transport{}
+	if m.server.Listener.Addr().Network() == "unix" {
+		transport.DialContext = m.dialVirtualHosts
+	}
 	if m.config.Protocol == HTTPS {
-		// Simple HTTPS client
-		tlsConfig := &tls.Config{}
-		if m.config.TLSConfig != nil {
-			// Default client should always skip verification for normal HTTPS
-			// (unless explicitly required otherwise)
-			tlsConfig.InsecureSkipVerify = true
-		} else {
-			tlsConfig.InsecureSkipVerify = true
-		}
-		transport.TLSClientConfig = tlsConfig
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 	return &http.Client{Transport: transport}
 }
 
-// mTLSClient returns an *http.Client configured for mutual TLS.
-// It uses the TLSOptions from the server. The caller provides client certificates and RootCAs.
-// This is useful for testing mTLS scenarios where the server verifies the client certificate.
-func (m *MockServer) mTLSClient(clientCerts []tls.Certificate, rootCAs *x509.CertPool) *http.Client {
-	tlsConfig := &tls.Config{
-		Certificates: clientCerts, // allow multiple client certs
-		RootCAs:      rootCAs,     // trust server cert
-		MinVersion:   tls.VersionTLS12,
+// Client returns an *http.Client that verifies the server certificate against
+// TrustedRootCAs instead of skipping verification. Requests to registered
+// virtual hosts are dialed to the mock server, so URLs such as
+// https://api.partner.com/ reach it directly.
+// The trusted roots are captured when Client is called; call it again after
+// rotating certificates to trust the new ones. With Config.InMemory, Client
+// and DefaultClient send requests through Transport.
+func (m *MockServer) Client() *http.Client {
+	return m.newVerifyingClient(nil)
+}
+
+// ClientWithCert returns an *http.Client like Client that also presents cert
+// to the server, for testing mutual TLS.
+func (m *MockServer) ClientWithCert(cert tls.Certificate) *http.Client {
+	return m.newVerifyingClient([]tls.Certificate{cert})
+}
+
+// TrustedRootCAs returns a pool containing the certificates currently served
+// by the mock server: the configured server certificates, every virtual host
+// certificate and, when intercepting proxied TLS, the signing CA. For an HTTP
+// server the pool is empty.
+func (m *MockServer) TrustedRootCAs() *x509.CertPool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pool := x509.NewCertPool()
+	if m.tlsConfig == nil {
+		return pool
+	}
+	for _, cert := range m.certificates {
+		if leaf := certificateLeaf(cert); leaf != nil {
+			pool.AddCert(leaf)
+		}
 	}
+	for _, vh := range m.virtualHosts {
+		if leaf := certificateLeaf(vh.certificate); leaf != nil {
+			pool.AddCert(leaf)
+		}
+	}
+	if m.proxyCA != nil {
+		if leaf := certificateLeaf(*m.proxyCA); leaf != nil {
+			pool.AddCert(leaf)
+		}
+	}
+	return pool
+}
 
-	// If the server requires client certs, ensure the client provides one
-	if m.config.TLSConfig != nil && m.config.TLSConfig.RequireClientCert {
-		tlsConfig.InsecureSkipVerify = false
-	} else {
-		tlsConfig.InsecureSkipVerify = true
+// newVerifyingClient builds the client behind Client and ClientWithCert.
+func (m *MockServer) newVerifyingClient(certs []tls.Certificate) *http.Client {
+	if m.server == nil {
+		return &http.Client{Transport: m.Transport()}
+	}
+	transport := &http.Transport{
+		DialContext: m.dialVirtualHosts,
+	}
+	if m.tlsConfig != nil {
+		transport.TLSClientConfig = &tls.Config{
+			Certificates: certs,
+			RootCAs:      m.TrustedRootCAs(),
+			MinVersion:   tls.VersionTLS12,
+		}
+		transport.ForceAttemptHTTP2 = m.config.Protocol == HTTPS && containsString(m.tlsConfig.NextProtos, "h2")
 	}
+	return &http.Client{Transport: transport}
+}
 
-	return &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
+// dialVirtualHosts dials the mock server for registered virtual host names and
+// the requested address otherwise. A server on a Unix socket is dialed for
+// every address.
+func (m *MockServer) dialVirtualHosts(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	if listenAddr := m.server.Listener.Addr(); listenAddr.Network() == "unix" {
+		return dialer.DialContext(ctx, "unix", listenAddr.String())
 	}
+	m.mu.RLock()
+	_, isVirtualHost := m.virtualHosts[hostWithoutPort(addr)]
+	m.mu.RUnlock()
+	if isVirtualHost {
+		addr = m.server.Listener.Addr().String()
+	}
+	return dialer.DialContext(ctx, network, addr)
 }
 
 // Use adds middleware to the mock server (applied to all requests).
 func (m *MockServer) Use(middleware func(http.Handler) http.Handler) {
-	m.server.Config.Handler = middleware(m.server.Config.Handler)
+	m.handlerChain = middleware(m.handlerChain)
+}
+
+// serveHTTP serves r with the handler chain, including middleware.
+func (m *MockServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.isMetricsRequest(r) {
+		m.MetricsHandler().ServeHTTP(w, r)
+		return
+	}
+	m.observeRequest(w, m.countConnRequest(w, r), m.handlerChain)
 }
 func (e *ExpectationError) Error() string {
 	result := e.Message
@@ -1586,8 +1586,11 @@ func TestHTTPSWithMutualTLS(t *testing.T) {
 		WithPath("/secure").
 		AndRespondWithString("ok", 200),
 	)
+	if !server.TrustedRootCAs().Equal(serverCertPool) {
+		t.Fatal("expected TrustedRootCAs to contain exactly the server certificate")
+	}
 	// Make request
-	resp, err := server.mTLSClient([]tls.Certificate{clientCert}, serverCertPool).Get(server.URL() + "/secure")
+	resp, err := server.ClientWithCert(clientCert).Get(server.URL() + "/secure")
 	if err != nil {
 		t.Fatalf("unexpected error during mTLS request: %v", err)
 	}
@@ -1865,6 +1868,9 @@ func TestHTTPSWithTrustedAndUntrustedClients(t *testing.T) {
 	if ok := serverCertPool.AppendCertsFromPEM(serverCertData); !ok {
 		t.Fatal("failed to append server cert to client trust pool")
 	}
+	if !server.TrustedRootCAs().Equal(serverCertPool) {
+		t.Fatal("expected TrustedRootCAs to contain exactly the server certificate")
+	}
 
 	type testClient struct {
 		cert     tls.Certificate
@@ -1884,7 +1890,7 @@ func TestHTTPSWithTrustedAndUntrustedClients(t *testing.T) {
 	for _, tc := range clients {
 		go func(tc testClient) {
 			defer wg.Done()
-			client := server.mTLSClient([]tls.Certificate{tc.cert}, serverCertPool)
+			client := server.ClientWithCert(tc.cert)
 			resp, err := client.Get(server.URL() + "/secure")
 			if tc.expectOK {
 				if err != nil {
@@ -2079,8 +2085,12 @@ func TestHTTPSWithMultipleExpectationsAndMutualTLS(t *testing.T) {
 		t.Fatal("failed to append server cert to client trust pool")
 	}
 
+	if !server.TrustedRootCAs().Equal(serverCertPool) {
+		t.Fatal("expected TrustedRootCAs to contain exactly the server certificate")
+	}
+
 	// Create mutual TLS client
-	mtlsClient := server.mTLSClient([]tls.Certificate{clientCert}, serverCertPool)
+	mtlsClient := server.ClientWithCert(clientCert)
 
 	// Test GET expectation
 	resp, err := mtlsClient.Get(server.URL() + "/get")
@@ -2123,3 +2133,195 @@ func TestHTTPSWithMultipleExpectationsAndMutualTLS(t *testing.T) {
 		t.Fatalf("GET /unknown: expected %d, got %d", http.StatusTeapot, unmatchedResp.StatusCode)
 	}
 }
+
+// TestMockServer_GetRecordedRequests ensures the journal records both matched
+// and unmatched requests in arrival order, and can be cleared.
+func TestMockServer_GetRecordedRequests(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{LogUnmatched: false})
+	defer ms.Close()
+
+	exp := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/orders").
+		AndRespondWithString("created", 201)
+	ms.AddExpectation(exp)
+
+	resp, err := http.Post(ms.URL()+"/orders?src=test", "text/plain", strings.NewReader("order-1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	resp, err = http.Get(ms.URL() + "/missing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	requests := ms.GetRecordedRequests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 recorded requests, got %d", len(requests))
+	}
+	first, second := requests[0], requests[1]
+	if first.Method != "POST" || first.URL != "/orders?src=test" || first.Body != "order-1" ||
+		!first.Matched || first.Expectation != exp || first.TLS != nil {
+		t.Errorf("unexpected first journal entry: %+v", first)
+	}
+	if second.URL != "/missing" || second.Matched || second.Expectation != nil {
+		t.Errorf("unexpected second journal entry: %+v", second)
+	}
+
+	ms.ClearRecordedRequests()
+	if len(ms.GetRecordedRequests()) != 0 {
+		t.Error("expected journal to be cleared")
+	}
+}
+
+func TestMockServer_MultiValuedResponseHeaders(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/items").
+		AndRespondWithString("[]", 200).
+		WithResponseHeader("Content-Type", "application/json").
+		AddResponseHeader("Link", `</items?page=2>; rel="next"`).
+		AddResponseHeader("Link", `</items?page=9>; rel="last"`).
+		WithResponseHeaderValues("Vary", []string{"Accept", "Accept-Encoding"}))
+
+	resp, err := http.Get(ms.URL() + "/items")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer safeClose(t, resp.Body)
+
+	if got := resp.Header.Values("Link"); !reflect.DeepEqual(got, []string{`</items?page=2>; rel="next"`, `</items?page=9>; rel="last"`}) {
+		t.Errorf("unexpected Link headers: %q", got)
+	}
+	if got := resp.Header.Values("Vary"); !reflect.DeepEqual(got, []string{"Accept", "Accept-Encoding"}) {
+		t.Errorf("unexpected Vary headers: %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected Content-Type: %q", got)
+	}
+}
+
+func TestMockServer_ResponseTrailers(t *testing.T) {
+	for _, protocol := range []Protocol{HTTP, HTTPS} {
+		t.Run(string(protocol), func(t *testing.T) {
+			config := &Config{Protocol: protocol}
+			if protocol == HTTPS {
+				config.TLSConfig = &TLSOptions{NextProtos: []string{"h2", "http/1.1"}}
+			}
+			ms := NewMockServerWithConfig(config)
+			defer ms.Close()
+			ms.AddExpectation(NewExpectation().
+				WithRequestMethod("POST").
+				WithPath("/rpc").
+				AndRespondWithString("payload", 200).
+				WithResponseTrailer("Grpc-Status", "0").
+				WithResponseTrailer("Grpc-Message", "OK"))
+
+			resp, err := ms.Client().Post(ms.URL()+"/rpc", "application/grpc", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer safeClose(t, resp.Body)
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading body: %v", err)
+			}
+			if string(body) != "payload" {
+				t.Errorf("unexpected body: %q", body)
+			}
+			if resp.Trailer.Get("Grpc-Status") != "0" || resp.Trailer.Get("Grpc-Message") != "OK" {
+				t.Errorf("unexpected trailers: %v", resp.Trailer)
+			}
+			if resp.Header.Get("Grpc-Status") != "" {
+				t.Error("expected trailer not to be sent as a header")
+			}
+		})
+	}
+}
+
+func TestMockServer_ExpectationSelection(t *testing.T) {
+	get := func(t *testing.T, ms *MockServer, path string, headers map[string]string) string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ms.URL()+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer safeClose(t, resp.Body)
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	catchAll := func() *Expectation {
+		return NewExpectation().WithRequestMethod("GET").WithPath("/users/{id}").AndRespondWithString("pattern", 200)
+	}
+	exact := func() *Expectation {
+		return NewExpectation().WithRequestMethod("GET").WithPath("/users/admin").AndRespondWithString("exact", 200)
+	}
+
+	t.Run("registration order by default", func(t *testing.T) {
+		ms := NewMockServer()
+		defer ms.Close()
+		ms.AddExpectation(catchAll())
+		ms.AddExpectation(exact())
+		if got := get(t, ms, "/users/admin", nil); got != "pattern" {
+			t.Errorf("expected first registered expectation, got %q", got)
+		}
+	})
+
+	t.Run("priority wins over registration order", func(t *testing.T) {
+		ms := NewMockServerWithConfig(&Config{MatchMostSpecific: true})
+		defer ms.Close()
+		ms.AddExpectation(exact())
+		ms.AddExpectation(catchAll().WithPriority(5))
+		if got := get(t, ms, "/users/admin", nil); got != "pattern" {
+			t.Errorf("expected higher priority expectation, got %q", got)
+		}
+	})
+
+	t.Run("most specific path", func(t *testing.T) {
+		ms := NewMockServerWithConfig(&Config{MatchMostSpecific: true})
+		defer ms.Close()
+		ms.AddExpectation(catchAll())
+		ms.AddExpectation(exact())
+		if got := get(t, ms, "/users/admin", nil); got != "exact" {
+			t.Errorf("expected exact path to win, got %q", got)
+		}
+		if got := get(t, ms, "/users/42", nil); got != "pattern" {
+			t.Errorf("expected pattern to match other ids, got %q", got)
+		}
+	})
+
+	t.Run("more constraints win, ties by registration order", func(t *testing.T) {
+		ms := NewMockServerWithConfig(&Config{MatchMostSpecific: true})
+		defer ms.Close()
+		ms.AddExpectation(exact())
+		ms.AddExpectation(NewExpectation().WithRequestMethod("GET").WithPath("/users/admin").AndRespondWithString("tie", 200))
+		ms.AddExpectation(exact().WithHeader("Accept", "application/json").AndRespondWithString("json", 200))
+		if got := get(t, ms, "/users/admin", map[string]string{"Accept": "application/json"}); got != "json" {
+			t.Errorf("expected expectation with more constraints, got %q", got)
+		}
+		if got := get(t, ms, "/users/admin", nil); got != "exact" {
+			t.Errorf("expected first registered among equally specific, got %q", got)
+		}
+	})
+
+	t.Run("exhausted expectation falls back", func(t *testing.T) {
+		ms := NewMockServerWithConfig(&Config{MatchMostSpecific: true})
+		defer ms.Close()
+		ms.AddExpectation(catchAll())
+		ms.AddExpectation(exact().Once())
+		if got := get(t, ms, "/users/admin", nil); got != "exact" {
+			t.Errorf("expected exact path first, got %q", got)
+		}
+		if got := get(t, ms, "/users/admin", nil); got != "pattern" {
+			t.Errorf("expected fallback once exact is exhausted, got %q", got)
+		}
+	})
+}
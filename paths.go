@@ -0,0 +1,168 @@
+package moxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// pathVariablePattern matches {name} and typed {name:type} path variables.
+var pathVariablePattern = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)(?::([a-zA-Z]+))?}`)
+
+// pathVariableTypes maps typed path variables to the regex they capture.
+var pathVariableTypes = map[string]string{
+	"":     `[^/]+`,
+	"int":  `[0-9]+`,
+	"uuid": `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+// WithPathGlob sets a glob path pattern for the Expectation: * matches within
+// a single segment, ** matches across segments ("/files/**" matches
+// "/files/a/b.txt") and "/**/" also matches a single slash. Path variables,
+// including typed ones, are supported as in WithPath. Other characters match
+// literally.
+// Example: .WithPathGlob("/repos/{owner}/*/contents/**")
+func (e *Expectation) WithPathGlob(pattern string) *Expectation {
+	e.Request.PathPattern = compilePathPattern(pattern, "^"+expandPathTemplate(pattern, globToRegex)+"$")
+	return e
+}
+
+// WithPathPrefix matches any path starting with prefix at a segment boundary:
+// "/api" matches "/api" and "/api/users" but not "/apiary". A prefix ending in
+// a slash matches anything after it. Path variables are supported.
+// Example: .WithPathPrefix("/api/v1")
+func (e *Expectation) WithPathPrefix(prefix string) *Expectation {
+	expanded := expandPathTemplate(prefix, regexp.QuoteMeta)
+	if strings.HasSuffix(prefix, "/") {
+		expanded += ".*"
+	} else {
+		expanded += "(?:/.*)?"
+	}
+	e.Request.PathPattern = compilePathPattern(prefix, "^"+expanded+"$")
+	return e
+}
+
+// WithOptionalTrailingSlash makes the path matcher tolerate a missing or extra
+// trailing slash, so "/users" and "/users/" are equivalent.
+func (e *Expectation) WithOptionalTrailingSlash() *Expectation {
+	e.Request.TrailingSlashOptional = true
+	return e
+}
+
+// WithRawPathMatching matches the path in its escaped form (r.URL.EscapedPath)
+// instead of the decoded r.URL.Path, so an encoded slash (%2F) does not split
+// a segment. Captured path variables are unescaped before being compared.
+// Example: .WithPath("/files/{name}").WithRawPathMatching() matches "/files/a%2Fb" with name "a/b"
+func (e *Expectation) WithRawPathMatching() *Expectation {
+	e.Request.MatchRawPath = true
+	return e
+}
+
+// convertBracesToRegex converts a WithPath pattern to an anchored regex,
+// replacing {var} with a named group. Typed variables ({id:int}, {id:uuid})
+// only capture matching values. The rest of the pattern is a regex.
+func convertBracesToRegex(pattern string) string {
+	return "^" + expandPathTemplate(pattern, func(s string) string { return s }) + "$"
+}
+
+// expandPathTemplate replaces path variables with named groups and converts
+// the text between them with literal.
+func expandPathTemplate(pattern string, literal func(string) string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range pathVariablePattern.FindAllStringSubmatchIndex(pattern, -1) {
+		name := pattern[loc[2]:loc[3]]
+		varType := ""
+		if loc[4] != -1 {
+			varType = pattern[loc[4]:loc[5]]
+		}
+		expr, ok := pathVariableTypes[varType]
+		if !ok {
+			panic(fmt.Sprintf("invalid path pattern %q: unknown path variable type %q (supported: int, uuid)", pattern, varType))
+		}
+		sb.WriteString(literal(pattern[last:loc[0]]))
+		sb.WriteString("(?P<" + name + ">" + expr + ")")
+		last = loc[1]
+	}
+	sb.WriteString(literal(pattern[last:]))
+	return sb.String()
+}
+
+// globToRegex converts glob text without path variables to a regex.
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString(`(?:.*/)?`)
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(`.*`)
+			i++
+		case glob[i] == '*':
+			sb.WriteString(`[^/]*`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String()
+}
+
+// compilePathPattern compiles a converted path pattern, panicking on error.
+func compilePathPattern(pattern, regexPattern string) *regexp.Regexp {
+	compiled, err := regexp.Compile(regexPattern)
+	if err != nil {
+		panic(fmt.Sprintf("invalid path pattern %q: %v", pattern, err))
+	}
+	return compiled
+}
+
+// pathMismatch explains why the request path does not match the path pattern
+// and variables. It returns an empty string if they match.
+func (req *RequestExpectation) pathMismatch(r *http.Request) string {
+	path := r.URL.Path
+	if req.MatchRawPath {
+		path = r.URL.EscapedPath()
+	}
+	pathMatches := req.PathPattern.FindStringSubmatch(path)
+	if pathMatches == nil && req.TrailingSlashOptional && path != "/" {
+		if strings.HasSuffix(path, "/") {
+			pathMatches = req.PathPattern.FindStringSubmatch(strings.TrimSuffix(path, "/"))
+		} else {
+			pathMatches = req.PathPattern.FindStringSubmatch(path + "/")
+		}
+	}
+	if pathMatches == nil {
+		return fmt.Sprintf("path: %q does not match %s", path, req.PathPattern)
+	}
+	// Capture named groups from regex
+	groupNames := req.PathPattern.SubexpNames()
+	capturedGroups := make(map[string]string, len(groupNames))
+	for groupIndex, groupName := range groupNames {
+		if groupIndex > 0 && groupName != "" {
+			value := pathMatches[groupIndex]
+			if req.MatchRawPath {
+				if unescaped, err := url.PathUnescape(value); err == nil {
+					value = unescaped
+				}
+			}
+			capturedGroups[groupName] = value
+		}
+	}
+	// Validate that all path variables exactly match expectation
+	for _, variableKey := range sortedKeys(req.PathVariables) {
+		expectedValue := req.PathVariables[variableKey]
+		actualValue, found := capturedGroups[variableKey]
+		if !found {
+			// Variable not found in the request path
+			return fmt.Sprintf("path variable %q: not captured by %s", variableKey, req.PathPattern)
+		}
+		if expectedValue != actualValue {
+			// Value mismatch → fail
+			return fmt.Sprintf("path variable %q: expected %q, got %q", variableKey, expectedValue, actualValue)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package moxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPathMatching(t *testing.T) {
+	cases := []struct {
+		name  string
+		exp   *Expectation
+		path  string
+		match bool
+	}{
+		{"glob single segment", NewExpectation().WithPathGlob("/files/*.txt"), "/files/a.txt", true},
+		{"glob single segment does not cross slash", NewExpectation().WithPathGlob("/files/*.txt"), "/files/dir/a.txt", false},
+		{"glob double star", NewExpectation().WithPathGlob("/files/**"), "/files/dir/sub/a.txt", true},
+		{"glob double star empty", NewExpectation().WithPathGlob("/files/**"), "/files/", true},
+		{"glob double star middle", NewExpectation().WithPathGlob("/files/**/a.txt"), "/files/x/y/a.txt", true},
+		{"glob double star middle zero segments", NewExpectation().WithPathGlob("/files/**/a.txt"), "/files/a.txt", true},
+		{"glob literal dot", NewExpectation().WithPathGlob("/files/a.txt"), "/files/abtxt", false},
+		{"glob with variable", NewExpectation().WithPathGlob("/repos/{owner}/*/contents/**").WithPathVariable("owner", "acme"), "/repos/acme/moxy/contents/docs/README.md", true},
+		{"prefix exact", NewExpectation().WithPathPrefix("/api"), "/api", true},
+		{"prefix nested", NewExpectation().WithPathPrefix("/api"), "/api/users/1", true},
+		{"prefix segment boundary", NewExpectation().WithPathPrefix("/api"), "/apiary", false},
+		{"prefix trailing slash", NewExpectation().WithPathPrefix("/static/"), "/static/app.js", true},
+		{"prefix literal", NewExpectation().WithPathPrefix("/v1.0"), "/v1x0/users", false},
+		{"typed int", NewExpectation().WithPath("/users/{id:int}"), "/users/42", true},
+		{"typed int rejects", NewExpectation().WithPath("/users/{id:int}"), "/users/me", false},
+		{"typed uuid", NewExpectation().WithPath("/orders/{id:uuid}"), "/orders/0b5e0c3a-8f3e-4c1e-9a49-6f0c6a1f2e9d", true},
+		{"typed uuid rejects", NewExpectation().WithPath("/orders/{id:uuid}"), "/orders/123", false},
+		{"typed variable value", NewExpectation().WithPath("/users/{id:int}").WithPathVariable("id", "42"), "/users/42", true},
+		{"strict trailing slash", NewExpectation().WithPath("/users"), "/users/", false},
+		{"optional trailing slash added", NewExpectation().WithPath("/users").WithOptionalTrailingSlash(), "/users/", true},
+		{"optional trailing slash removed", NewExpectation().WithPath("/users/").WithOptionalTrailingSlash(), "/users", true},
+		{"decoded path splits encoded slash", NewExpectation().WithPath("/files/{name}"), "/files/a%2Fb", false},
+		{"raw path keeps encoded slash", NewExpectation().WithPath("/files/{name}").WithPathVariable("name", "a/b").WithRawPathMatching(), "/files/a%2Fb", true},
+		{"raw path literal", NewExpectation().WithPathPrefix("/files/a%2Fb").WithRawPathMatching(), "/files/a%2Fb/c", true},
+	}
+	for _, tc := range cases {
+		e := tc.exp.WithRequestMethod("GET")
+		r, _ := http.NewRequest("GET", tc.path, nil)
+		if got := e.matches(r, nil); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v (%s)", tc.name, tc.match, got, e.mismatch(r, nil))
+		}
+	}
+}
+
+func TestPathMatching_UnknownVariableTypePanics(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), `unknown path variable type "float"`) {
+			t.Errorf("expected panic for unknown type, got %v", r)
+		}
+	}()
+	NewExpectation().WithPath("/users/{id:float}")
+}
+
+func TestMockServer_RawPathMatching(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/objects/{key}").
+		WithPathVariable("key", "dir/file.txt").
+		WithRawPathMatching().
+		AndRespondWithString("found", 200))
+
+	resp, err := http.Get(ms.URL() + "/objects/dir%2Ffile.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected encoded key to match, got %d", resp.StatusCode)
+	}
+}
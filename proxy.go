@@ -0,0 +1,163 @@
+package moxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// proxyContextKey carries the ProxiedResponse being recorded for a request.
+type proxyContextKey struct{}
+
+// ProxyUnmatchedTo makes the server forward requests that match no expectation
+// to baseURL using httputil.ReverseProxy, instead of responding with
+// UnmatchedStatusCode. This lets a test mock a few endpoints of a large API
+// and pass everything else through to a real implementation. Proxied requests
+// are journaled on arrival and are not reported as unmatched; their upstream
+// response is recorded as it streams to the client.
+// It panics if baseURL is not an absolute URL. The returned options can be
+// used to rewrite headers.
+// Example:
+//
+//	cfg := &Config{}
+//	cfg.ProxyUnmatchedTo("http://localhost:8080").SetRequestHeader("Authorization", "Bearer test")
+func (c *Config) ProxyUnmatchedTo(baseURL string) *ProxyOptions {
+	target, err := url.Parse(baseURL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		panic(fmt.Sprintf("invalid proxy target %q: must be an absolute URL", baseURL))
+	}
+	c.ProxyUnmatched = &ProxyOptions{Target: target}
+	return c.ProxyUnmatched
+}
+
+// SetRequestHeader sets a header on every forwarded request.
+func (p *ProxyOptions) SetRequestHeader(key, value string) *ProxyOptions {
+	if p.SetRequestHeaders == nil {
+		p.SetRequestHeaders = make(map[string]string)
+	}
+	p.SetRequestHeaders[key] = value
+	return p
+}
+
+// RemoveRequestHeader removes a header from every forwarded request.
+func (p *ProxyOptions) RemoveRequestHeader(key string) *ProxyOptions {
+	p.RemoveRequestHeaders = append(p.RemoveRequestHeaders, key)
+	return p
+}
+
+// SetResponseHeader sets a header on every upstream response.
+func (p *ProxyOptions) SetResponseHeader(key, value string) *ProxyOptions {
+	if p.SetResponseHeaders == nil {
+		p.SetResponseHeaders = make(map[string]string)
+	}
+	p.SetResponseHeaders[key] = value
+	return p
+}
+
+// newReverseProxy builds the reverse proxy for Config.ProxyUnmatched.
+func (m *MockServer) newReverseProxy(opts *ProxyOptions) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(opts.Target)
+			pr.SetXForwarded()
+			if opts.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+			for _, key := range opts.RemoveRequestHeaders {
+				pr.Out.Header.Del(key)
+			}
+			for key, value := range opts.SetRequestHeaders {
+				pr.Out.Header.Set(key, value)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			for key, value := range opts.SetResponseHeaders {
+				resp.Header.Set(key, value)
+			}
+			if recorded, ok := resp.Request.Context().Value(proxyContextKey{}).(*ProxiedResponse); ok {
+				m.mu.Lock()
+				recorded.StatusCode = resp.StatusCode
+				recorded.Headers = resp.Header.Clone()
+				recorded.body = &bytes.Buffer{}
+				m.mu.Unlock()
+				// Record the body as it streams to the client instead of
+				// buffering it first.
+				resp.Body = &recordingBody{ReadCloser: resp.Body, server: m, recorded: recorded}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if recorded, ok := r.Context().Value(proxyContextKey{}).(*ProxiedResponse); ok {
+				m.mu.Lock()
+				recorded.StatusCode = http.StatusBadGateway
+				recorded.Error = err.Error()
+				m.mu.Unlock()
+			}
+			m.logger.Printf("Failed to proxy %s %s: %v", r.Method, r.URL.RequestURI(), err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+// proxyUnmatched forwards an unmatched request upstream. The request is
+// journaled when it arrives; its upstream response is filled in as the
+// exchange progresses.
+func (m *MockServer) proxyUnmatched(w http.ResponseWriter, r *http.Request, body []byte, record RecordedRequest) {
+	if m.slogger == nil && m.config.VerboseLogging {
+		m.logger.Printf("Proxying unmatched request %s %s to %s", r.Method, r.URL.RequestURI(), m.config.ProxyUnmatched.Target)
+	}
+	upstream := &ProxiedResponse{}
+	record.Proxied = true
+	record.Upstream = upstream
+	m.mu.Lock()
+	m.journal(record)
+	m.mu.Unlock()
+
+	r = r.WithContext(context.WithValue(r.Context(), proxyContextKey{}, upstream))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	m.proxy.ServeHTTP(w, r)
+
+	m.mu.Lock()
+	m.metrics.proxied++
+	record = record.snapshot()
+	m.mu.Unlock()
+	m.runHooks(record, nil)
+}
+
+// recordingBody copies an upstream response body into the journal as the
+// reverse proxy reads it.
+type recordingBody struct {
+	io.ReadCloser
+	server   *MockServer
+	recorded *ProxiedResponse
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.server.mu.Lock()
+		b.recorded.body.Write(p[:n])
+		b.server.mu.Unlock()
+	}
+	return n, err
+}
+
+// snapshot returns a copy of the record that is safe to hand out while the
+// upstream response of a proxied request is still being received.
+// Callers must hold m.mu.
+func (r RecordedRequest) snapshot() RecordedRequest {
+	if r.Upstream != nil {
+		upstream := *r.Upstream
+		if upstream.body != nil {
+			upstream.Body = upstream.body.String()
+			upstream.body = nil
+		}
+		r.Upstream = &upstream
+	}
+	return r
+}
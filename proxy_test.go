@@ -0,0 +1,185 @@
+package moxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMockServer_ProxyUnmatchedTo(t *testing.T) {
+	var upstreamRequest *http.Request
+	var upstreamBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequest = r
+		body, _ := io.ReadAll(r.Body)
+		upstreamBody = string(body)
+		w.Header().Set("X-Upstream", "real")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "from upstream "+r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	config := &Config{}
+	config.ProxyUnmatchedTo(upstream.URL+"/base").
+		SetRequestHeader("Authorization", "Bearer upstream").
+		RemoveRequestHeader("X-Test-Only").
+		SetResponseHeader("X-Proxied-By", "moxy")
+	ms := NewMockServerWithConfig(config)
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/users/1").
+		AndRespondWithString("mocked", 200))
+
+	resp, err := http.Get(ms.URL() + "/users/1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if string(body) != "mocked" || upstreamRequest != nil {
+		t.Fatalf("expected mocked endpoint to be served locally, got %q", body)
+	}
+
+	req, _ := http.NewRequest("POST", ms.URL()+"/orders?x=1", strings.NewReader("order"))
+	req.Header.Set("X-Test-Only", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+
+	if resp.StatusCode != http.StatusCreated || string(body) != "from upstream /base/orders?x=1" {
+		t.Errorf("unexpected proxied response: %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Upstream") != "real" || resp.Header.Get("X-Proxied-By") != "moxy" {
+		t.Errorf("unexpected proxied response headers: %v", resp.Header)
+	}
+	if upstreamBody != "order" {
+		t.Errorf("expected request body to be forwarded, got %q", upstreamBody)
+	}
+	if upstreamRequest.Header.Get("Authorization") != "Bearer upstream" || upstreamRequest.Header.Get("X-Test-Only") != "" {
+		t.Errorf("unexpected forwarded headers: %v", upstreamRequest.Header)
+	}
+	if upstreamRequest.Host != strings.TrimPrefix(upstream.URL, "http://") {
+		t.Errorf("expected Host to be rewritten to the target, got %q", upstreamRequest.Host)
+	}
+
+	if unmatched := ms.GetUnmatchedRequests(); len(unmatched) != 0 {
+		t.Errorf("expected proxied requests not to be reported as unmatched, got %+v", unmatched)
+	}
+	journal := ms.GetRecordedRequests()
+	if len(journal) != 2 || journal[0].Proxied || journal[0].Upstream != nil {
+		t.Fatalf("unexpected journal: %+v", journal)
+	}
+	proxied := journal[1]
+	if !proxied.Proxied || proxied.Matched || proxied.Body != "order" || proxied.URL != "/orders?x=1" {
+		t.Errorf("unexpected proxied journal entry: %+v", proxied)
+	}
+	if proxied.Upstream == nil || proxied.Upstream.StatusCode != http.StatusCreated ||
+		proxied.Upstream.Body != "from upstream /base/orders?x=1" || proxied.Upstream.Headers["X-Upstream"][0] != "real" {
+		t.Errorf("unexpected upstream response: %+v", proxied.Upstream)
+	}
+}
+
+func TestMockServer_ProxyPreserveHostAndUpstreamDown(t *testing.T) {
+	var host string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+
+	config := &Config{}
+	config.ProxyUnmatchedTo(upstream.URL).PreserveHost = true
+	ms := NewMockServerWithConfig(config)
+	defer ms.Close()
+	ms.WithLogger(log.New(io.Discard, "", 0))
+
+	req, _ := http.NewRequest("GET", ms.URL()+"/", nil)
+	req.Host = "tenant.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if host != "tenant.example.com" {
+		t.Errorf("expected original Host to be preserved, got %q", host)
+	}
+
+	upstream.Close()
+	resp, err = http.Get(ms.URL() + "/down")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected 502 when upstream is down, got %d", resp.StatusCode)
+	}
+	journal := ms.GetRecordedRequests()
+	last := journal[len(journal)-1]
+	if last.Upstream == nil || last.Upstream.StatusCode != http.StatusBadGateway || last.Upstream.Error == "" {
+		t.Errorf("expected upstream error to be journaled, got %+v", last.Upstream)
+	}
+}
+
+func TestConfig_ProxyUnmatchedToInvalidPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for relative proxy target")
+		}
+	}()
+	(&Config{}).ProxyUnmatchedTo("/relative")
+}
+
+// TestMockServer_ProxyStreamsAndJournalsOnArrival checks that a proxied
+// response reaches the client while the upstream is still writing it, and
+// that the request is journaled in arrival order before the exchange ends.
+func TestMockServer_ProxyStreamsAndJournalsOnArrival(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second")
+	}))
+	defer upstream.Close()
+	finish := sync.OnceFunc(func() { close(release) })
+	defer finish()
+	config := &Config{}
+	config.ProxyUnmatchedTo(upstream.URL)
+	ms := NewMockServerWithConfig(config)
+	defer ms.Close()
+	addPing(ms)
+
+	resp, err := ms.Client().Get(ms.URL() + "/stream")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer safeClose(t, resp.Body)
+	chunk := make([]byte, len("first "))
+	if _, err := io.ReadFull(resp.Body, chunk); err != nil || string(chunk) != "first " {
+		t.Fatalf("expected the first chunk before the upstream finished, got %q, %v", chunk, err)
+	}
+
+	getWith(t, ms.Client(), ms.URL()+"/ping")
+	journal := ms.GetRecordedRequests()
+	if len(journal) != 2 || journal[0].URL != "/stream" || journal[1].URL != "/ping" {
+		t.Fatalf("expected the proxied request to be journaled first, got %+v", journal)
+	}
+	if journal[0].Upstream == nil || journal[0].Upstream.StatusCode != http.StatusOK || journal[0].Upstream.Body != "first " {
+		t.Errorf("expected the partial upstream response, got %+v", journal[0].Upstream)
+	}
+
+	finish()
+	rest, _ := io.ReadAll(resp.Body)
+	if string(rest) != "second" {
+		t.Errorf("expected the rest of the stream, got %q", rest)
+	}
+	if upstream := ms.GetRecordedRequests()[0].Upstream; upstream.Body != "first second" {
+		t.Errorf("expected the full upstream body once complete, got %q", upstream.Body)
+	}
+}
@@ -0,0 +1,129 @@
+package moxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+// RotateCertificate replaces the server's certificates, including any
+// configured with TLSOptions.Certificates, with cert.
+// The new certificate is used for all subsequent TLS handshakes; existing
+// connections keep the certificate they were established with. The server
+// keeps running on the same URL.
+func (m *MockServer) RotateCertificate(cert tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certificates = []tls.Certificate{cert}
+}
+
+// RotateClientCAs replaces the pool used to verify client certificates when
+// TLSOptions.RequireClientCert is set. It takes effect for new handshakes.
+func (m *MockServer) RotateClientCAs(pool *x509.CertPool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clientCAs = pool
+}
+
+// RotateCertificate replaces the certificate served for this virtual host.
+// It takes effect for new handshakes.
+func (v *VirtualHost) RotateCertificate(cert tls.Certificate) {
+	v.server.mu.Lock()
+	defer v.server.mu.Unlock()
+	v.certificate = cert
+}
+
+// Certificate returns the server's current default certificate, the first
+// of its certificates.
+func (m *MockServer) Certificate() tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.certificates) == 0 {
+		return tls.Certificate{}
+	}
+	return m.certificates[0]
+}
+
+// configForClient is used as tls.Config.GetConfigForClient so that every
+// handshake picks up the current client CA pool and certificate.
+func (m *MockServer) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cfg := m.tlsConfig.Clone()
+	cfg.GetConfigForClient = nil
+	// Without static certificates GetCertificate is consulted for every handshake,
+	// including ones without SNI.
+	cfg.Certificates = nil
+	cfg.ClientCAs = m.clientCAs
+	return cfg, nil
+}
+
+// selectCertificate is used as tls.Config.GetCertificate. It selects the
+// virtual host certificate by SNI server name, falling back to the first of
+// the server's certificates the client supports (as crypto/tls would with
+// tls.Config.Certificates) or else the default one, and remembers which one
+// served the connection.
+func (m *MockServer) selectCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var cert tls.Certificate
+	if vh, ok := m.virtualHosts[hostWithoutPort(hello.ServerName)]; ok {
+		cert = vh.certificate
+	} else {
+		cert = m.serverCertificate(hello)
+	}
+	m.rememberConnCert(hello, cert)
+	return &cert, nil
+}
+
+// serverCertificate picks the server certificate for hello. Callers must hold
+// m.mu.
+func (m *MockServer) serverCertificate(hello *tls.ClientHelloInfo) tls.Certificate {
+	if len(m.certificates) > 1 {
+		for _, cert := range m.certificates {
+			if hello.SupportsCertificate(&cert) == nil {
+				return cert
+			}
+		}
+	}
+	return m.certificates[0]
+}
+
+// rememberConnCert records the certificate served on the connection of hello
+// for the request journal. Callers must hold m.mu.
+func (m *MockServer) rememberConnCert(hello *tls.ClientHelloInfo, cert tls.Certificate) {
+	if hello.Conn == nil {
+		return
+	}
+	if m.connCerts == nil {
+		m.connCerts = make(map[string]*x509.Certificate)
+	}
+	m.connCerts[hello.Conn.RemoteAddr().String()] = certificateLeaf(cert)
+}
+
+// tlsDetails describes the TLS connection of r for the request journal.
+// Callers must hold m.mu.
+func (m *MockServer) tlsDetails(r *http.Request) *TLSDetails {
+	if r.TLS == nil {
+		return nil
+	}
+	return &TLSDetails{
+		ServerName:         r.TLS.ServerName,
+		ServerCertificate:  m.connCerts[r.RemoteAddr],
+		PeerCertificates:   r.TLS.PeerCertificates,
+		Version:            r.TLS.Version,
+		CipherSuite:        r.TLS.CipherSuite,
+		NegotiatedProtocol: r.TLS.NegotiatedProtocol,
+		DidResume:          r.TLS.DidResume,
+	}
+}
+
+// VersionName returns the name of the negotiated TLS version, e.g. "TLS 1.3".
+func (d *TLSDetails) VersionName() string {
+	return tls.VersionName(d.Version)
+}
+
+// CipherSuiteName returns the standard name of the negotiated cipher suite.
+func (d *TLSDetails) CipherSuiteName() string {
+	return tls.CipherSuiteName(d.CipherSuite)
+}
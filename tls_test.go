@@ -0,0 +1,496 @@
+package moxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// newInsecureClient returns a client that skips server verification and does
+// not reuse connections, so every request performs a fresh handshake.
+func newInsecureClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// TestRotateCertificate verifies that a rotated certificate is served for new
+// handshakes on the same URL and is recorded in the request journal.
+func TestRotateCertificate(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/ping").
+		AndRespondWithString("pong", 200))
+
+	url := ms.URL()
+	before := certificateLeaf(ms.Certificate())
+	client := newInsecureClient()
+
+	resp, err := client.Get(url + "/ping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if !resp.TLS.PeerCertificates[0].Equal(before) {
+		t.Fatal("expected the initial certificate to be served")
+	}
+
+	rotated, _, err := generateSelfSignedCert("rotated.local")
+	if err != nil {
+		t.Fatalf("failed to generate certificate: %v", err)
+	}
+	ms.RotateCertificate(rotated)
+	if ms.URL() != url {
+		t.Errorf("expected URL to stay %s, got %s", url, ms.URL())
+	}
+
+	resp, err = client.Get(url + "/ping")
+	if err != nil {
+		t.Fatalf("unexpected error after rotation: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if !resp.TLS.PeerCertificates[0].Equal(rotated.Leaf) {
+		t.Fatal("expected the rotated certificate to be served")
+	}
+
+	requests := ms.GetRecordedRequests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 recorded requests, got %d", len(requests))
+	}
+	if requests[0].TLS == nil || !requests[0].TLS.ServerCertificate.Equal(before) {
+		t.Errorf("expected first request to record the initial certificate")
+	}
+	if requests[1].TLS == nil || !requests[1].TLS.ServerCertificate.Equal(rotated.Leaf) {
+		t.Errorf("expected second request to record the rotated certificate")
+	}
+}
+
+// TestRotateCertificate_ExistingConnection ensures an established keep-alive
+// connection keeps the certificate it was handshaken with.
+func TestRotateCertificate_ExistingConnection(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/ping").
+		AndRespondWithString("pong", 200))
+
+	before := certificateLeaf(ms.Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ms.URL() + "/ping")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		safeClose(t, resp.Body)
+		if !resp.TLS.PeerCertificates[0].Equal(before) {
+			t.Fatalf("request %d: expected the initial certificate on the reused connection", i)
+		}
+		if i == 0 {
+			rotated, _, _ := generateSelfSignedCert("rotated.local")
+			ms.RotateCertificate(rotated)
+		}
+	}
+}
+
+// TestRotateClientCAs verifies that rotating the client CA pool changes which
+// client certificates are accepted for new handshakes.
+func TestRotateClientCAs(t *testing.T) {
+	trustedCert, err := tls.LoadX509KeyPair("testdata/client.crt", "testdata/client.key")
+	if err != nil {
+		t.Fatalf("failed to load client cert/key: %v", err)
+	}
+	otherCert, err := tls.LoadX509KeyPair("testdata/untrusted_client.crt", "testdata/untrusted_client.key")
+	if err != nil {
+		t.Fatalf("failed to load untrusted client cert/key: %v", err)
+	}
+	poolFor := func(path string) *x509.CertPool {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			t.Fatalf("failed to append %s to pool", path)
+		}
+		return pool
+	}
+
+	ms := NewMockServerWithConfig(&Config{
+		Protocol: HTTPS,
+		TLSConfig: &TLSOptions{
+			RequireClientCert: true,
+			ClientCAs:         poolFor("testdata/client.crt"),
+		},
+	})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/secure").
+		AndRespondWithString("ok", 200))
+
+	get := func(cert tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates:       []tls.Certificate{cert},
+				InsecureSkipVerify: true,
+			},
+		}}
+		resp, err := client.Get(ms.URL() + "/secure")
+		if err == nil {
+			safeClose(t, resp.Body)
+		}
+		return err
+	}
+
+	if err := get(trustedCert); err != nil {
+		t.Fatalf("expected trusted client to succeed before rotation: %v", err)
+	}
+	if err := get(otherCert); err == nil {
+		t.Fatal("expected other client to fail before rotation")
+	}
+
+	ms.RotateClientCAs(poolFor("testdata/untrusted_client.crt"))
+
+	if err := get(trustedCert); err == nil {
+		t.Fatal("expected previously trusted client to fail after rotation")
+	}
+	if err := get(otherCert); err != nil {
+		t.Fatalf("expected newly trusted client to succeed after rotation: %v", err)
+	}
+
+	requests := ms.GetRecordedRequests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 recorded requests, got %d", len(requests))
+	}
+	last := requests[1].TLS
+	if last == nil || len(last.PeerCertificates) == 0 || !last.PeerCertificates[0].Equal(certificateLeaf(otherCert)) {
+		t.Error("expected journal to record the client certificate")
+	}
+}
+
+// TestVirtualHost_RotateCertificate verifies rotation of a virtual host certificate.
+func TestVirtualHost_RotateCertificate(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+	vh := ms.AddVirtualHost("api.partner.com", nil)
+	vh.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200))
+
+	rotated, _, err := generateSelfSignedCert("api.partner.com")
+	if err != nil {
+		t.Fatalf("failed to generate certificate: %v", err)
+	}
+	vh.RotateCertificate(rotated)
+
+	client := virtualHostClient(t, ms, rotated)
+	resp, err := client.Get("https://api.partner.com/")
+	if err != nil {
+		t.Fatalf("expected rotated virtual host certificate to be trusted: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	requests := ms.GetRecordedRequests()
+	if len(requests) != 1 || requests[0].TLS.ServerName != "api.partner.com" ||
+		!requests[0].TLS.ServerCertificate.Equal(rotated.Leaf) {
+		t.Errorf("unexpected journal entry: %+v", requests)
+	}
+}
+
+// TestTLSOptions_NegotiatedParameters verifies MaxVersion, CipherSuites and
+// CurvePreferences are enforced and the negotiated values are journaled.
+func TestTLSOptions_NegotiatedParameters(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{
+		Protocol: HTTPS,
+		TLSConfig: &TLSOptions{
+			MaxVersion:       tls.VersionTLS12,
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			CurvePreferences: []tls.CurveID{tls.CurveP256},
+		},
+	})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200))
+
+	resp, err := newInsecureClient().Get(ms.URL() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+
+	details := ms.GetRecordedRequests()[0].TLS
+	if details.Version != tls.VersionTLS12 || details.VersionName() != "TLS 1.2" {
+		t.Errorf("expected TLS 1.2, got %s", details.VersionName())
+	}
+	if details.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suite %s", details.CipherSuiteName())
+	}
+}
+
+// TestTLSOptions_ClientRefusesDowngrade simulates a server limited to old TLS
+// versions and checks that a client requiring TLS 1.2 refuses to connect.
+func TestTLSOptions_ClientRefusesDowngrade(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{
+		Protocol: HTTPS,
+		TLSConfig: &TLSOptions{
+			MinVersion: tls.VersionTLS10,
+			MaxVersion: tls.VersionTLS11,
+		},
+	})
+	defer ms.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: true,
+		},
+	}}
+	if _, err := client.Get(ms.URL() + "/"); err == nil {
+		t.Fatal("expected handshake failure for a TLS 1.1-only server")
+	}
+	if len(ms.GetRecordedRequests()) != 0 {
+		t.Error("expected no requests to reach the server")
+	}
+}
+
+// TestTLSOptions_HTTP2 verifies that advertising h2 via NextProtos serves HTTP/2.
+func TestTLSOptions_HTTP2(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{
+		Protocol:  HTTPS,
+		TLSConfig: &TLSOptions{NextProtos: []string{"h2", "http/1.1"}},
+	})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200))
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get(ms.URL() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 response, got %s", resp.Proto)
+	}
+	if got := ms.GetRecordedRequests()[0].TLS.NegotiatedProtocol; got != "h2" {
+		t.Errorf("expected negotiated protocol h2, got %q", got)
+	}
+}
+
+// TestTLSOptions_SessionResumption verifies that resumption is journaled and
+// can be disabled with SessionTicketsDisabled.
+func TestTLSOptions_SessionResumption(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		ms := NewMockServerWithConfig(&Config{
+			Protocol:  HTTPS,
+			TLSConfig: &TLSOptions{SessionTicketsDisabled: disabled},
+		})
+		ms.AddExpectation(NewExpectation().
+			WithRequestMethod("GET").
+			WithPath("/").
+			AndRespondWithString("ok", 200))
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				ClientSessionCache: tls.NewLRUClientSessionCache(4),
+			},
+			DisableKeepAlives: true,
+		}}
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(ms.URL() + "/")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, _ = io.ReadAll(resp.Body)
+			safeClose(t, resp.Body)
+		}
+		requests := ms.GetRecordedRequests()
+		ms.Close()
+
+		if requests[0].TLS.DidResume {
+			t.Error("expected first connection to perform a full handshake")
+		}
+		if requests[1].TLS.DidResume == disabled {
+			t.Errorf("SessionTicketsDisabled=%v: unexpected DidResume=%v on second connection",
+				disabled, requests[1].TLS.DidResume)
+		}
+	}
+}
+
+// TestTLSOptions_MultipleCertificates verifies that the certificate is chosen
+// among TLSOptions.Certificates by SNI, that all of them are trusted by
+// TrustedRootCAs and that RotateCertificate replaces the whole set.
+func TestTLSOptions_MultipleCertificates(t *testing.T) {
+	certA, _, _ := generateSelfSignedCert("a.example")
+	certB, _, _ := generateSelfSignedCert("b.example")
+	ms := NewMockServerWithConfig(&Config{
+		Protocol:  HTTPS,
+		TLSConfig: &TLSOptions{Certificates: []tls.Certificate{certA, certB}},
+	})
+	defer ms.Close()
+	addPing(ms)
+
+	client := virtualHostClient(t, ms)
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.RootCAs = ms.TrustedRootCAs()
+	transport.DisableKeepAlives = true
+	for _, host := range []string{"a.example", "b.example"} {
+		resp, err := client.Get("https://" + host + "/ping")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", host, err)
+		}
+		safeClose(t, resp.Body)
+		if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != host {
+			t.Errorf("%s: expected the %s certificate, got CN=%s", host, host, cn)
+		}
+	}
+	if !ms.Certificate().Leaf.Equal(certA.Leaf) {
+		t.Error("expected the first certificate to be the default")
+	}
+
+	rotated, _, _ := generateSelfSignedCert("rotated.local")
+	ms.RotateCertificate(rotated)
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	resp, err := client.Get("https://b.example/ping")
+	if err != nil {
+		t.Fatalf("unexpected error after rotation: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if !resp.TLS.PeerCertificates[0].Equal(rotated.Leaf) {
+		t.Error("expected the rotated certificate to replace all configured certificates")
+	}
+}
+
+// TestClient_VerifiesGeneratedCertificate checks that Client trusts the
+// generated certificate through verification rather than skipping it.
+func TestClient_VerifiesGeneratedCertificate(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/ping").
+		AndRespondWithString("pong", 200))
+
+	client := ms.Client()
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("expected Client to verify the server certificate")
+	}
+	transport.DisableKeepAlives = true
+	resp, err := client.Get(ms.URL() + "/ping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if len(resp.TLS.VerifiedChains) == 0 {
+		t.Error("expected a verified certificate chain")
+	}
+
+	// A client created before rotation no longer trusts the server.
+	rotated, _, _ := generateSelfSignedCert("localhost")
+	ms.RotateCertificate(rotated)
+	if _, err := client.Get(ms.URL() + "/ping"); err == nil {
+		t.Error("expected stale client to reject the rotated certificate")
+	}
+	resp, err = ms.Client().Get(ms.URL() + "/ping")
+	if err != nil {
+		t.Fatalf("expected fresh client to trust the rotated certificate: %v", err)
+	}
+	safeClose(t, resp.Body)
+}
+
+// TestClient_VirtualHosts verifies Client trusts and dials virtual hosts by name.
+func TestClient_VirtualHosts(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+	ms.AddVirtualHost("api.partner.com", nil).AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/v1").
+		AndRespondWithString("partner", 200))
+
+	resp, err := ms.Client().Get("https://api.partner.com/v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if string(body) != "partner" {
+		t.Errorf("expected body 'partner', got %q", string(body))
+	}
+}
+
+// TestClientWithCert_GeneratedServerCertificate exercises mTLS with a
+// verified generated server certificate and a file-based client certificate.
+func TestClientWithCert_GeneratedServerCertificate(t *testing.T) {
+	clientCert, err := tls.LoadX509KeyPair("testdata/client.crt", "testdata/client.key")
+	if err != nil {
+		t.Fatalf("failed to load client cert/key: %v", err)
+	}
+	clientData, _ := os.ReadFile("testdata/client.crt")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientData)
+
+	ms := NewMockServerWithConfig(&Config{
+		Protocol: HTTPS,
+		TLSConfig: &TLSOptions{
+			RequireClientCert: true,
+			ClientCAs:         clientCAs,
+		},
+	})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/secure").
+		AndRespondWithString("ok", 200))
+
+	resp, err := ms.ClientWithCert(clientCert).Get(ms.URL() + "/secure")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != 200 {
+		t.Errorf("expected 200 OK, got %d", resp.StatusCode)
+	}
+	if _, err := ms.Client().Get(ms.URL() + "/secure"); err == nil {
+		t.Error("expected handshake failure without a client certificate")
+	}
+}
+
+// TestClient_HTTP ensures Client works for plain HTTP servers.
+func TestClient_HTTP(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/").
+		AndRespondWithString("ok", 200))
+
+	resp, err := ms.Client().Get(ms.URL() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if !ms.TrustedRootCAs().Equal(x509.NewCertPool()) {
+		t.Error("expected an empty trust pool for HTTP")
+	}
+}
@@ -0,0 +1,143 @@
+package moxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// inMemoryHost is the host of URL for servers created with Config.InMemory.
+const inMemoryHost = "moxy.test"
+
+// inMemoryRemoteAddr is the client address of requests served in-process.
+const inMemoryRemoteAddr = "127.0.0.1:0"
+
+// Transport returns an http.RoundTripper that serves requests directly with
+// the server's matching engine, without a listener or connections. Requests
+// are journaled and verified as if they had been sent over the network, and
+// middleware added with Use applies. Every host is served by this server
+// unless routed elsewhere with Route.
+// Example: client := &http.Client{Transport: ms.Transport()}
+func (m *MockServer) Transport() *InMemoryTransport {
+	return &InMemoryTransport{fallback: m}
+}
+
+// NewInMemoryTransport returns an InMemoryTransport without a fallback
+// server: only hosts registered with Route are served.
+// Example: NewInMemoryTransport().Route("api.github.com", github).Route("api.stripe.com", stripe)
+func NewInMemoryTransport() *InMemoryTransport {
+	return &InMemoryTransport{}
+}
+
+// Route serves requests for host with ms. host is a hostname, matching any
+// port, or a host:port pair, which takes precedence.
+func (t *InMemoryTransport) Route(host string, ms *MockServer) *InMemoryTransport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.routes == nil {
+		t.routes = make(map[string]*MockServer)
+	}
+	t.routes[strings.ToLower(host)] = ms
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *InMemoryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	ms := t.serverFor(host)
+	if ms == nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, fmt.Errorf("no mock server routed for host %q", host)
+	}
+	return ms.serveInMemory(req, host)
+}
+
+// serverFor returns the server routed for host, or the fallback server.
+func (t *InMemoryTransport) serverFor(host string) *MockServer {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if ms, ok := t.routes[strings.ToLower(host)]; ok {
+		return ms
+	}
+	if ms, ok := t.routes[hostWithoutPort(host)]; ok {
+		return ms
+	}
+	return t.fallback
+}
+
+// serveInMemory serves a client request with the handler chain. The request
+// is converted to its server-side form: requests for https URLs carry a
+// minimal TLS connection state so scheme matchers work, and the client
+// address is the loopback address. While the server is stopped or paused,
+// requests fail as connections would. The response is returned once the handler
+// completes, or with the context error if the request is canceled first.
+func (m *MockServer) serveInMemory(req *http.Request, host string) (*http.Response, error) {
+	ctx := req.Context()
+	switch m.listener.currentMode() {
+	case RefuseConnections:
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, fmt.Errorf("connection to %s refused: server is stopped or paused", host)
+	case BlackHoleConnections:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	r := req.Clone(ctx)
+	r.URL = &url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	r.RequestURI = r.URL.RequestURI()
+	r.Host = host
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.1", 1, 1
+	r.RemoteAddr = inMemoryRemoteAddr
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	if strings.EqualFold(req.URL.Scheme, "https") {
+		r.TLS = &tls.ConnectionState{
+			Version:           tls.VersionTLS13,
+			HandshakeComplete: true,
+			ServerName:        hostWithoutPort(host),
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					m.logger.Printf("Panic serving %s %s: %v", r.Method, r.RequestURI, p)
+				}
+				done <- fmt.Errorf("handler panicked serving %s %s: %v", r.Method, r.RequestURI, p)
+			}
+		}()
+		m.serveHTTP(rec, r)
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+	}
+	// A canceled client never sees a response, even if the handler completed.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resp := rec.Result()
+	resp.Request = req
+	if req.Method == http.MethodHead {
+		resp.Body = http.NoBody
+	}
+	return resp, nil
+}
@@ -0,0 +1,199 @@
+package moxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInMemoryTransport_MatchesAndJournals(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{InMemory: true})
+	defer ms.Close()
+	exp := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/users").
+		WithQueryParam("dry_run", "true").
+		WithRequestBody([]byte(`{"name":"Alice"}`)).
+		WithRemoteIP("127.0.0.1").
+		Times(1).
+		AndRespondWithString(`{"id":1}`, 201).
+		WithResponseHeader("Content-Type", "application/json")
+	ms.AddExpectation(exp)
+
+	if ms.URL() != "http://moxy.test" {
+		t.Errorf("unexpected in-memory URL %q", ms.URL())
+	}
+	client := &http.Client{Transport: ms.Transport()}
+	resp, err := client.Post(ms.URL()+"/users?dry_run=true", "application/json", strings.NewReader(`{"name":"Alice"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusCreated || string(body) != `{"id":1}` {
+		t.Errorf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected response headers, got %v", resp.Header)
+	}
+
+	resp, err = ms.Client().Get(ms.URL() + "/missing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected unmatched status, got %d", resp.StatusCode)
+	}
+
+	if err := ms.VerifyExpectations(); err != nil {
+		t.Errorf("expected expectations to be met: %v", err)
+	}
+	journal := ms.GetRecordedRequests()
+	if len(journal) != 2 || journal[0].URL != "/users?dry_run=true" || journal[0].Host != "moxy.test" {
+		t.Errorf("unexpected journal: %+v", journal)
+	}
+	if len(ms.GetUnmatchedRequests()) != 1 {
+		t.Errorf("expected one unmatched request, got %d", len(ms.GetUnmatchedRequests()))
+	}
+}
+
+func TestInMemoryTransport_SchemeAndMiddleware(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{InMemory: true, Protocol: HTTPS})
+	defer ms.Close()
+	ms.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Middleware", "applied")
+			next.ServeHTTP(w, r)
+		})
+	})
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithScheme("https").
+		WithPath("/secure").
+		AndRespondWithString("ok", 200).
+		WithResponseTrailer("X-Checksum", "abc"))
+
+	resp, err := ms.Client().Get("https://api.example.com/secure")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	safeClose(t, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected https request to match, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-Middleware") != "applied" {
+		t.Error("expected middleware to apply to in-memory requests")
+	}
+	if resp.Trailer.Get("X-Checksum") != "abc" {
+		t.Errorf("expected trailer, got %v", resp.Trailer)
+	}
+	if record := ms.GetRecordedRequests()[0]; record.TLS == nil || record.TLS.ServerName != "api.example.com" {
+		t.Errorf("expected TLS details for an https request, got %+v", record.TLS)
+	}
+}
+
+func TestInMemoryTransport_HostRouting(t *testing.T) {
+	github := NewMockServerWithConfig(&Config{InMemory: true})
+	defer github.Close()
+	github.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/user").
+		AndRespondWithString("github", 200))
+	stripe := NewMockServer()
+	defer stripe.Close()
+	stripe.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/v1/balance").
+		AndRespondWithString("stripe", 200))
+	stripeTest := NewMockServerWithConfig(&Config{InMemory: true})
+	defer stripeTest.Close()
+	stripeTest.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/v1/balance").
+		AndRespondWithString("stripe test port", 200))
+
+	client := &http.Client{Transport: NewInMemoryTransport().
+		Route("api.github.com", github).
+		Route("API.stripe.com", stripe).
+		Route("api.stripe.com:8443", stripeTest)}
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.github.com/user", "github"},
+		{"https://api.stripe.com/v1/balance", "stripe"},
+		{"http://api.stripe.com:8080/v1/balance", "stripe"},
+		{"https://api.stripe.com:8443/v1/balance", "stripe test port"},
+	}
+	for _, tc := range cases {
+		resp, err := client.Get(tc.url)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		safeClose(t, resp.Body)
+		if string(body) != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.url, tc.want, body)
+		}
+	}
+	if len(stripe.GetRecordedRequests()) != 2 || len(github.GetRecordedRequests()) != 1 {
+		t.Error("expected requests to be journaled by the routed servers")
+	}
+
+	if _, err := client.Get("https://unknown.example.com/"); err == nil || !strings.Contains(err.Error(), `no mock server routed for host "unknown.example.com"`) {
+		t.Errorf("expected routing error, got %v", err)
+	}
+}
+
+func TestInMemoryTransport_Cancellation(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{InMemory: true})
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/hang").
+		SimulateTimeout())
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/slow").
+		WithResponseDelay(time.Second).
+		AndRespondWithString("late", 200))
+
+	client := &http.Client{Transport: ms.Transport(), Timeout: 50 * time.Millisecond}
+	for _, path := range []string{"/hang", "/slow"} {
+		start := time.Now()
+		_, err := client.Get(ms.URL() + path)
+		if err == nil {
+			t.Fatalf("%s: expected timeout error", path)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: expected the client timeout to cut the request short, took %v", path, elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ms.URL()+"/hang", nil)
+	if _, err := ms.Transport().RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestInMemoryTransport_HandlerPanic(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{InMemory: true})
+	defer ms.Close()
+	ms.Use(func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })
+	})
+	ms.WithLogger(log.New(io.Discard, "", 0))
+
+	if _, err := ms.Client().Get(ms.URL() + "/"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected handler panic to surface as an error, got %v", err)
+	}
+}
@@ -1,11 +1,16 @@
 package moxy
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"regexp"
 	"sync"
 	"time"
@@ -19,13 +24,25 @@ const (
 	HTTPS Protocol = "https"
 )
 
+// PauseMode selects how a paused server treats new connections.
+type PauseMode int
+
+const (
+	RefuseConnections    PauseMode = iota + 1 // new connections are refused, as if nothing listened on the address
+	BlackHoleConnections                      // new connections are accepted but never served, so clients time out
+)
+
 // ResponseDefinition defines a mock response for an expectation.
 type ResponseDefinition struct {
 	StatusCode        int
 	Body              []byte
 	Headers           map[string]string
-	Delay             time.Duration // optional delay before sending response
-	TimeoutSimulation bool          // if true, server never responds
+	HeaderValues      http.Header    // repeated headers, added after Headers
+	Trailers          http.Header    // trailers written after the body
+	Delay             time.Duration  // optional delay before sending response
+	TimeoutSimulation bool           // if true, server never responds
+	Cookies           []*http.Cookie // cookies written as Set-Cookie headers
+	graphQL           *graphQLResponse
 }
 
 // RequestExpectation defines the expected request structure.
@@ -34,11 +51,99 @@ type RequestExpectation struct {
 	Path          string
 	PathPattern   *regexp.Regexp
 	PathVariables map[string]string
-	Body          []byte
-	BodyMatcher   func([]byte) bool
-	QueryParams   map[string]string
-	Headers       map[string]string // stored as lowercase keys for case-insensitive matching
-	BodyFromFile  bool
+	// Path matching options
+	TrailingSlashOptional bool // "/users" and "/users/" are equivalent
+	MatchRawPath          bool // match r.URL.EscapedPath instead of r.URL.Path
+	Body                  []byte
+	BodyMatcher           func([]byte) bool
+	QueryParams           map[string]string
+	Headers               map[string]string // stored as lowercase keys for case-insensitive matching
+	BodyFromFile          bool
+	// Multi-valued query and header matching
+	QueryParamValues  map[string]ValuesExpectation
+	HeaderValues      map[string]ValuesExpectation // stored as lowercase keys
+	AbsentQueryParams []string                     // params that must not be present
+	StrictQueryParams bool                         // reject params that are not expected
+	// Connection-level matching
+	HostPattern   *regexp.Regexp // matched against r.Host, case-insensitively
+	Scheme        string         // "http" or "https"
+	RemoteNet     *net.IPNet     // network the client address must belong to
+	Proto         string         // e.g. "HTTP/1.1" or "HTTP/2.0"
+	ContentLength ValueMatcher   // matched against r.ContentLength (-1 if unknown)
+	// Cookie matching
+	Cookies        map[string]string
+	SessionCookies []string // cookies whose value must have been issued by the server
+	// Form matching (application/x-www-form-urlencoded or multipart/form-data)
+	FormFields      map[string]string
+	MultipartFields map[string]string
+	MultipartFiles  []MultipartFileExpectation
+	// JSONPath matchers evaluated against the decoded JSON body
+	JSONPaths []JSONPathExpectation
+	// JSON Schema the body must validate against
+	JSONSchema *JSONSchema
+	// XPath matchers evaluated against the parsed XML body
+	XPaths []XPathExpectation
+	// SOAP action from the SOAPAction header or the SOAP 1.2 Content-Type
+	SOAPAction string
+	// GraphQL envelope matchers
+	GraphQL *GraphQLExpectation
+}
+
+// GraphQLExpectation describes the expected GraphQL request envelope.
+type GraphQLExpectation struct {
+	OperationName string                 // expected operation name; empty matches any
+	QueryContains []string               // substrings the query document must contain
+	Variables     map[string]interface{} // variables the request must contain (partial match)
+}
+
+// GraphQLError is a single entry of the errors list in a GraphQL response.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Locations  []GraphQLLocation      `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLLocation is a position in a GraphQL query document.
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// JSONPathExpectation pairs a JSONPath expression with the matcher its value must satisfy.
+type JSONPathExpectation struct {
+	Path    string
+	Matcher ValueMatcher
+	steps   []jsonPathStep
+}
+
+// ValuesExpectation describes every expected value of a repeated query
+// parameter or header. Unordered expectations compare values as a multiset.
+type ValuesExpectation struct {
+	Values  []string
+	Ordered bool
+}
+
+// XPathExpectation pairs an XPath expression with the value it must select.
+type XPathExpectation struct {
+	Expr  string
+	Value string
+	steps []xpathStep
+}
+
+// MultipartFileExpectation describes a file part expected in a multipart/form-data body.
+type MultipartFileExpectation struct {
+	Field          string            // form field name
+	Filename       string            // expected filename; empty matches any
+	ContentMatcher func([]byte) bool // optional matcher for the file content
+}
+
+// UploadedFile is a file part received in a multipart/form-data request.
+type UploadedFile struct {
+	Field       string
+	Filename    string
+	ContentType string
+	Content     []byte
 }
 
 // Expectation defines a mock expectation for HTTP requests.
@@ -50,37 +155,199 @@ type Expectation struct {
 	InvocationCount     int
 	MaxCalls            *int // nil means unlimited
 	NextResponseIndex   int  // tracks which response to return next
+	Priority            int  // higher priorities are matched first (default: 0)
 }
 
 // MockServer represents a lightweight HTTP mock server for testing HTTP clients.
 type MockServer struct {
-	server             *httptest.Server
+	server             *httptest.Server // nil when Config.InMemory is set
+	handlerChain       http.Handler     // request handler including middleware added with Use
 	expectations       []*Expectation
 	unmatchedRequests  []UnmatchedRequest
+	requests           []RecordedRequest            // request journal, matched and unmatched
+	virtualHosts       map[string]*VirtualHost      // keyed by lowercase hostname
+	tlsConfig          *tls.Config                  // base TLS config, cloned for every handshake
+	certificates       []tls.Certificate            // current server certificates, the first is the default
+	clientCAs          *x509.CertPool               // current pool for verifying client certificates
+	connCerts          map[string]*x509.Certificate // certificate served per connection, keyed by remote address
+	cookieSession      cookieSession                // cookies issued by responses, for WithSessionCookie
 	mu                 sync.RWMutex
 	logger             *log.Logger
 	config             Config
 	unmatchedResponder func(w http.ResponseWriter, r *http.Request, req UnmatchedRequest)
+	proxy              *httputil.ReverseProxy                   // forwards unmatched requests, nil unless Config.ProxyUnmatched is set
+	tunnelServer       *http.Server                             // serves connections inside CONNECT tunnels in forward proxy mode
+	tunnels            *tunnelListener                          // hands tunneled connections to tunnelServer
+	tunnelConns        map[net.Conn]struct{}                    // connections open on tunnelServer
+	proxyCA            *tls.Certificate                         // signs intercepted certificates, nil unless ForwardProxy.InterceptTLS is set
+	mintedCerts        map[string]*tls.Certificate              // intercepted certificates, keyed by lowercase hostname
+	listener           *pausableListener                        // controls new connections for Pause and Stop
+	conns              map[net.Conn]*ConnectionStats            // open connections
+	connHistory        []*ConnectionStats                       // every accepted connection, in order
+	metrics            serverMetrics                            // counters for MetricsHandler
+	slogger            *slog.Logger                             // structured request events, nil unless WithSlogHandler is used
+	logOptions         LogOptions                               // redaction and truncation of structured events
+	requestHooks       []func(RecordedRequest)                  // registered with OnRequest
+	matchHooks         map[*Expectation][]func(RecordedRequest) // registered with OnMatch
+	unmatchedHooks     []func(UnmatchedRequest)                 // registered with OnUnmatched
+	journalChanged     chan struct{}                            // closed when a request is journaled, for the Wait helpers
+	journalResets      uint64                                   // incremented by ClearRecordedRequests, for WaitForRequest
+}
+
+// InMemoryTransport is an http.RoundTripper that serves requests in-process
+// with the matching engine of a MockServer, without opening connections.
+// Requests are routed by host to the servers registered with Route, falling
+// back to the server that created the transport (see MockServer.Transport).
+type InMemoryTransport struct {
+	mu       sync.RWMutex
+	fallback *MockServer
+	routes   map[string]*MockServer // keyed by lowercase host or host:port
+}
+
+// VirtualHost is a named host served by a MockServer with its own
+// certificate (selected by SNI) and its own set of expectations (selected by
+// the request's Host header).
+type VirtualHost struct {
+	hostname     string
+	certificate  tls.Certificate
+	expectations []*Expectation
+	server       *MockServer
 }
 
 // UnmatchedRequest represents a request that didn't match any expectations
 type UnmatchedRequest struct {
-	Method    string
-	URL       string
-	Headers   map[string][]string
-	Body      string
-	Timestamp time.Time
+	Method     string
+	URL        string
+	Headers    map[string][]string
+	Body       string
+	Timestamp  time.Time
+	Mismatches []string // why each candidate expectation did not match
 }
 
 // Config holds configuration options for MockServer
 type Config struct {
-	Protocol               Protocol    // HTTP or HTTPS
-	TLSConfig              *TLSOptions // Server's custom TLS config
-	UnmatchedStatusCode    int         // Status code for unmatched requests (default: 418)
-	UnmatchedStatusMessage string      // Status message for unmatched requests (default: "Unmatched Request")
-	LogUnmatched           bool        // Whether to log unmatched requests (default: true)
-	MaxBodySize            int64       // Maximum request body size in bytes (default: 10MB)
-	VerboseLogging         bool        // Enable verbose request/response logging (default: false)
+	Protocol               Protocol             // HTTP or HTTPS
+	TLSConfig              *TLSOptions          // Server's custom TLS config
+	UnmatchedStatusCode    int                  // Status code for unmatched requests (default: 418)
+	UnmatchedStatusMessage string               // Status message for unmatched requests (default: "Unmatched Request")
+	LogUnmatched           bool                 // Whether to log unmatched requests (default: true)
+	MaxBodySize            int64                // Maximum request body size in bytes (default: 10MB)
+	VerboseLogging         bool                 // Enable verbose request/response logging (default: false)
+	MatchMostSpecific      bool                 // Among matches of equal priority, prefer the most specific (default: false, first registered wins)
+	ProxyUnmatched         *ProxyOptions        // Forward unmatched requests to a real upstream instead of responding with UnmatchedStatusCode
+	ForwardProxy           *ForwardProxyOptions // Act as an explicit HTTP proxy serving CONNECT tunnels (default: nil, disabled)
+	InMemory               bool                 // Serve requests only through Transport, without binding a port (default: false)
+	Address                string               // Listen on a fixed host:port, e.g. "127.0.0.1:8089" or "[::1]:0" for IPv6 (default: random 127.0.0.1 port)
+	UnixSocket             string               // Listen on a Unix domain socket at this path instead of TCP
+	Listener               net.Listener         // Serve on a caller-supplied listener, closed by Close
+	DisableKeepAlive       bool                 // Close every connection after one request (default: false)
+	MaxRequestsPerConn     int                  // Close HTTP/1.x connections after this many requests (default: 0, unlimited)
+	IdleTimeout            time.Duration        // Close keep-alive connections idle for longer than this (default: no timeout)
+	MetricsPath            string               // Serve Prometheus metrics on GET requests to this path, e.g. "/metrics" (default: disabled)
+}
+
+// ForwardProxyOptions configures forward proxy mode, where clients use the
+// mock server as their HTTP proxy (see MockServer.ProxyURL).
+type ForwardProxyOptions struct {
+	// Username and Password, if set, are required as Basic
+	// Proxy-Authorization credentials; other proxy requests get 407.
+	Username string
+	Password string
+	// InterceptTLS makes the server terminate tunneled TLS connections with
+	// a certificate for the requested host, minted on the fly and signed by
+	// CA, so any hostname can be mocked over HTTPS. Clients need to trust
+	// only the CA (see MockServer.CACertificate and TrustedRootCAs).
+	InterceptTLS bool
+	// CA signs intercepted certificates. If nil, a CA is generated.
+	CA *tls.Certificate
+}
+
+// ProxyDetails describes how a request reached the server in forward proxy mode.
+type ProxyDetails struct {
+	Tunneled      bool   // received inside a CONNECT tunnel
+	Target        string // CONNECT authority or absolute-form request host
+	Authorization string // Proxy-Authorization header sent to the proxy
+}
+
+// ProxyOptions configures forwarding of unmatched requests to an upstream
+// server. Use Config.ProxyUnmatchedTo to create it.
+type ProxyOptions struct {
+	Target               *url.URL          // upstream base URL; request paths are appended to its path
+	SetRequestHeaders    map[string]string // headers set on forwarded requests
+	RemoveRequestHeaders []string          // headers removed from forwarded requests
+	SetResponseHeaders   map[string]string // headers set on upstream responses
+	PreserveHost         bool              // forward the original Host header instead of the target host
+}
+
+// ProxiedResponse is the upstream response to a request forwarded by
+// Config.ProxyUnmatched, as recorded in the request journal. While the
+// exchange is in progress it holds what has been received so far.
+type ProxiedResponse struct {
+	StatusCode int
+	Headers    map[string][]string
+	Body       string
+	Error      string        // set if the upstream could not be reached
+	body       *bytes.Buffer // body received so far, guarded by MockServer.mu
+}
+
+// RecordedRequest is an entry in the request journal. Every request received
+// by the server is recorded, whether it matched an expectation or not.
+type RecordedRequest struct {
+	Method      string
+	URL         string
+	Host        string
+	Headers     map[string][]string
+	Body        string
+	Timestamp   time.Time
+	Matched     bool
+	Expectation *Expectation        // matched expectation, nil if unmatched
+	TLS         *TLSDetails         // nil for plain HTTP requests
+	Form        map[string][]string // parsed form fields for urlencoded and multipart bodies
+	Files       []UploadedFile      // uploaded files for multipart bodies
+	Proxied     bool                // forwarded upstream by Config.ProxyUnmatched
+	Upstream    *ProxiedResponse    // upstream response, nil unless Proxied
+	Proxy       *ProxyDetails       // set for requests sent to the server as a forward proxy
+	Connection  *ConnectionDetails  // connection the request arrived on, nil for in-memory requests
+}
+
+// LogOptions configures the structured events of MockServer.WithSlogHandler.
+type LogOptions struct {
+	// RedactHeaders lists headers whose values are logged as "[REDACTED]".
+	// nil means DefaultRedactedHeaders; an empty slice redacts nothing.
+	RedactHeaders []string
+	// RedactBody replaces matches in logged bodies with "[REDACTED]".
+	// Example: regexp.MustCompile(`"password":\s*"[^"]*"`)
+	RedactBody []*regexp.Regexp
+	// MaxBodyBytes truncates logged bodies (default: 1024); negative omits them.
+	MaxBodyBytes int
+}
+
+// ConnectionDetails identifies the connection a recorded request arrived on.
+type ConnectionDetails struct {
+	ID         uint64 // matches ConnectionStats.ID
+	RemoteAddr string // client address of the connection
+	Request    int    // 1 for the first request on the connection, >1 if it was reused
+}
+
+// ConnectionStats describes a client connection accepted by the server.
+type ConnectionStats struct {
+	ID         uint64         // sequential connection number, starting at 1
+	RemoteAddr string         // client address
+	Opened     time.Time      // when the connection was accepted
+	Closed     time.Time      // when the connection was closed or hijacked, zero while open
+	State      http.ConnState // latest state, e.g. http.StateIdle
+	Requests   int            // requests served on the connection
+}
+
+// TLSDetails describes the TLS connection a recorded request arrived on.
+type TLSDetails struct {
+	ServerName         string              // SNI server name sent by the client
+	ServerCertificate  *x509.Certificate   // certificate the server presented on the connection
+	PeerCertificates   []*x509.Certificate // certificates presented by the client (mTLS)
+	Version            uint16              // negotiated TLS version, e.g. tls.VersionTLS13
+	CipherSuite        uint16              // negotiated cipher suite
+	NegotiatedProtocol string              // negotiated ALPN protocol, e.g. "h2"
+	DidResume          bool                // whether the session was resumed
 }
 
 // ExpectationError represents errors related to unmet expectations
@@ -103,4 +370,15 @@ type TLSOptions struct {
 	InsecureSkipVerify bool
 	// e.g., tls.VersionTLS12
 	MinVersion uint16
+	// Highest TLS version the server accepts, e.g. tls.VersionTLS12 (0 means the Go default)
+	MaxVersion uint16
+	// Enabled cipher suites for TLS 1.2 and below (nil means the Go default).
+	// TLS 1.3 cipher suites are not configurable.
+	CipherSuites []uint16
+	// Elliptic curves in preference order (nil means the Go default)
+	CurvePreferences []tls.CurveID
+	// ALPN protocols in preference order (default: "http/1.1"); include "h2" to serve HTTP/2
+	NextProtos []string
+	// Disable session tickets, preventing clients from resuming sessions
+	SessionTicketsDisabled bool
 }
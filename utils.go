@@ -1,36 +1,96 @@
 package moxy
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"math/big"
 	"net"
+	"sort"
+	"strings"
 	"time"
 )
 
-func generateDefaultCert() tls.Certificate {
-	cert, _, err := generateSelfSignedCert("localhost")
+func generateDefaultCert(ips ...net.IP) tls.Certificate {
+	cert, _, err := generateSelfSignedCert("localhost", ips...)
 	if err != nil {
 		panic("failed to generate default TLS certificate: " + err.Error())
 	}
 	return cert
 }
 
-// Generate a self-signed certificate that includes 127.0.0.1 in SANs
-func generateSelfSignedCert(commonName string) (tls.Certificate, *x509.Certificate, error) {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// Generate a self-signed certificate that includes commonName, localhost, the
+// loopback addresses 127.0.0.1 and ::1, and ips in its SANs
+func generateSelfSignedCert(commonName string, ips ...net.IP) (tls.Certificate, *x509.Certificate, error) {
+	template, err := certificateTemplate(commonName)
 	if err != nil {
 		return tls.Certificate{}, nil, err
 	}
+	template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	for _, ip := range ips {
+		if !containsIP(template.IPAddresses, ip) {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		}
+	}
+	template.DNSNames = []string{commonName}
+	if commonName != "localhost" {
+		template.DNSNames = append(template.DNSNames, "localhost")
+	}
+	cert, err := createCertificate(template, nil, nil)
+	return cert, cert.Leaf, err
+}
+
+// generateCA generates a self-signed certificate authority for signing
+// intercepted certificates in forward proxy mode.
+func generateCA(commonName string) (tls.Certificate, error) {
+	template, err := certificateTemplate(commonName)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = nil
+	return createCertificate(template, nil, nil)
+}
+
+// generateSignedCert generates a certificate for host signed by ca. An IP
+// address host is put in the IP SANs, anything else in the DNS SANs.
+func generateSignedCert(host string, ca tls.Certificate) (tls.Certificate, error) {
+	caLeaf := certificateLeaf(ca)
+	signer, ok := ca.PrivateKey.(crypto.Signer)
+	if caLeaf == nil || !ok {
+		return tls.Certificate{}, errors.New("CA certificate has no usable leaf or private key")
+	}
+	template, err := certificateTemplate(host)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	cert, err := createCertificate(template, caLeaf, signer)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert.Certificate = append(cert.Certificate, ca.Certificate[0])
+	return cert, nil
+}
+
+// certificateTemplate returns a template for a short-lived server and client
+// certificate with a random serial number.
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
 	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
 	if err != nil {
-		return tls.Certificate{}, nil, err
+		return nil, err
 	}
-	template := x509.Certificate{
+	return &x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
 			CommonName: commonName,
@@ -43,16 +103,94 @@ func generateSelfSignedCert(commonName string) (tls.Certificate, *x509.Certifica
 			x509.ExtKeyUsageClientAuth,
 		},
 		BasicConstraintsValid: true,
-		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}, nil
+}
+
+// createCertificate generates a key and a certificate from template, signed
+// by parent and parentKey, or self-signed if parent is nil.
+func createCertificate(template, parent *x509.Certificate, parentKey crypto.Signer) (tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if parent == nil {
+		parent, parentKey = template, priv
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &priv.PublicKey, parentKey)
 	if err != nil {
-		return tls.Certificate{}, nil, err
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return tls.Certificate{}, err
 	}
 	cert := tls.Certificate{
 		Certificate: [][]byte{certDER},
 		PrivateKey:  priv,
-		Leaf:        &template,
+		Leaf:        leaf,
+	}
+	return cert, nil
+}
+
+// containsIP reports whether ips contains ip.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostWithoutPort strips an optional port from a host or host:port string
+// and lowercases the result.
+func hostWithoutPort(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// certificateLeaf returns the parsed leaf of cert, parsing it if Leaf is not populated.
+func certificateLeaf(cert tls.Certificate) *x509.Certificate {
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+// containsString reports whether values contains s.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order, for deterministic iteration.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// truncate returns b as a string, shortened to at most limit bytes.
+func truncate(b []byte, limit int) string {
+	if len(b) <= limit {
+		return string(b)
 	}
-	return cert, &template, nil
+	return string(b[:limit]) + "..."
 }
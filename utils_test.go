@@ -1,6 +1,7 @@
 package moxy
 
 import (
+	"crypto/x509"
 	"net"
 	"testing"
 )
@@ -58,3 +59,40 @@ func TestGenerateSelfSignedCert(t *testing.T) {
 		t.Fatal("expected IP 127.0.0.1 in certificate SANs")
 	}
 }
+
+func TestGenerateDefaultCert_ListenerAddresses(t *testing.T) {
+	cert := generateDefaultCert(net.ParseIP("10.1.2.3"), net.ParseIP("127.0.0.1"))
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "10.1.2.3"} {
+		if err := cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("expected the certificate to be valid for %s: %v", host, err)
+		}
+	}
+	if n := len(cert.Leaf.IPAddresses); n != 3 {
+		t.Errorf("expected 3 distinct IP SANs, got %v", cert.Leaf.IPAddresses)
+	}
+}
+
+func TestGenerateSignedCert(t *testing.T) {
+	ca, err := generateCA("test CA")
+	if err != nil {
+		t.Fatalf("unexpected error generating CA: %v", err)
+	}
+	if !ca.Leaf.IsCA {
+		t.Fatal("expected CA certificate to be a CA")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	for _, host := range []string{"api.stripe.com", "10.0.0.1"} {
+		cert, err := generateSignedCert(host, ca)
+		if err != nil {
+			t.Fatalf("unexpected error signing certificate for %s: %v", host, err)
+		}
+		if len(cert.Certificate) != 2 {
+			t.Errorf("expected leaf and CA in the chain, got %d certificates", len(cert.Certificate))
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("expected certificate for %s to verify against the CA: %v", host, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package moxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// AddVirtualHost registers a virtual host served by this mock server.
+// Requests whose Host header matches hostname are matched only against the
+// virtual host's own expectations. Over HTTPS, TLS handshakes whose SNI server
+// name matches hostname are served with cert. If cert is nil, a self-signed
+// certificate for hostname is generated.
+// Registering the same hostname twice returns the existing virtual host.
+// Example: ms.AddVirtualHost("api.partner.com", nil).AddExpectation(exp)
+func (m *MockServer) AddVirtualHost(hostname string, cert *tls.Certificate) *VirtualHost {
+	key := hostWithoutPort(hostname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if vh, ok := m.virtualHosts[key]; ok {
+		return vh
+	}
+	vh := &VirtualHost{
+		hostname: key,
+		server:   m,
+	}
+	if cert != nil {
+		vh.certificate = *cert
+	} else {
+		generated, _, err := generateSelfSignedCert(key)
+		if err != nil {
+			panic(fmt.Sprintf("failed to generate certificate for virtual host %q: %v", key, err))
+		}
+		vh.certificate = generated
+	}
+	if m.virtualHosts == nil {
+		m.virtualHosts = make(map[string]*VirtualHost)
+	}
+	m.virtualHosts[key] = vh
+	return vh
+}
+
+// VirtualHost returns the virtual host registered for hostname, or nil if none.
+func (m *MockServer) VirtualHost(hostname string) *VirtualHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.virtualHosts[hostWithoutPort(hostname)]
+}
+
+// RemoveVirtualHost unregisters a virtual host and its expectations.
+// Returns true if found and removed.
+func (m *MockServer) RemoveVirtualHost(hostname string) bool {
+	key := hostWithoutPort(hostname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.virtualHosts[key]; !ok {
+		return false
+	}
+	delete(m.virtualHosts, key)
+	return true
+}
+
+// Hostname returns the (lowercase) hostname of the virtual host.
+func (v *VirtualHost) Hostname() string {
+	return v.hostname
+}
+
+// Certificate returns the certificate served for this virtual host.
+func (v *VirtualHost) Certificate() tls.Certificate {
+	v.server.mu.RLock()
+	defer v.server.mu.RUnlock()
+	return v.certificate
+}
+
+// AddExpectation registers an expectation that only matches requests for this virtual host.
+func (v *VirtualHost) AddExpectation(e *Expectation) *VirtualHost {
+	v.server.mu.Lock()
+	defer v.server.mu.Unlock()
+	v.expectations = append(v.expectations, e)
+	return v
+}
+
+// RemoveExpectation removes a specific expectation from this virtual host. Returns true if found and removed.
+func (v *VirtualHost) RemoveExpectation(e *Expectation) bool {
+	v.server.mu.Lock()
+	defer v.server.mu.Unlock()
+	for i, exp := range v.expectations {
+		if exp == e {
+			v.expectations = append(v.expectations[:i], v.expectations[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearExpectations removes all expectations registered for this virtual host.
+func (v *VirtualHost) ClearExpectations() {
+	v.server.mu.Lock()
+	defer v.server.mu.Unlock()
+	v.expectations = v.expectations[:0]
+}
+
+// expectationsFor returns the expectation set a request should be matched against:
+// the virtual host's expectations if r.Host names a registered virtual host,
+// otherwise the server's default expectations. Callers must hold m.mu.
+func (m *MockServer) expectationsFor(r *http.Request) []*Expectation {
+	if vh, ok := m.virtualHosts[hostWithoutPort(r.Host)]; ok {
+		return vh.expectations
+	}
+	return m.expectations
+}
+
+// allExpectations returns the default and all virtual host expectations. Callers must hold m.mu.
+func (m *MockServer) allExpectations() []*Expectation {
+	all := make([]*Expectation, 0, len(m.expectations))
+	all = append(all, m.expectations...)
+	hostnames := make([]string, 0, len(m.virtualHosts))
+	for hostname := range m.virtualHosts {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		all = append(all, m.virtualHosts[hostname].expectations...)
+	}
+	return all
+}
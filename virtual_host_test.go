@@ -0,0 +1,160 @@
+package moxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// virtualHostClient returns a client that dials the mock server for every
+// hostname and trusts only the given certificates.
+func virtualHostClient(t *testing.T, ms *MockServer, certs ...tls.Certificate) *http.Client {
+	t.Helper()
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		roots.AddCert(leaf)
+	}
+	addr := strings.TrimPrefix(strings.TrimPrefix(ms.URL(), "https://"), "http://")
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// TestVirtualHosts_HTTPS verifies that each virtual host is served with its own
+// certificate and only matches its own expectations.
+func TestVirtualHosts_HTTPS(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+
+	api := ms.AddVirtualHost("api.partner.com", nil)
+	auth := ms.AddVirtualHost("auth.partner.com", nil)
+	api.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/whoami").
+		AndRespondWithString("api", 200))
+	auth.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/whoami").
+		AndRespondWithString("auth", 200))
+
+	client := virtualHostClient(t, ms, api.Certificate(), auth.Certificate())
+	for _, host := range []string{"api", "auth"} {
+		resp, err := client.Get("https://" + host + ".partner.com/whoami")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", host, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		safeClose(t, resp.Body)
+		if string(body) != host {
+			t.Errorf("expected body %q, got %q", host, string(body))
+		}
+		if got := resp.TLS.PeerCertificates[0].Subject.CommonName; got != host+".partner.com" {
+			t.Errorf("expected certificate for %s.partner.com, got %q", host, got)
+		}
+	}
+}
+
+// TestVirtualHosts_UntrustedCertificate ensures a client trusting only one
+// virtual host's certificate cannot connect to another virtual host.
+func TestVirtualHosts_UntrustedCertificate(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{Protocol: HTTPS})
+	defer ms.Close()
+
+	api := ms.AddVirtualHost("api.partner.com", nil)
+	ms.AddVirtualHost("auth.partner.com", nil)
+
+	client := virtualHostClient(t, ms, api.Certificate())
+	if _, err := client.Get("https://auth.partner.com/"); err == nil {
+		t.Fatal("expected TLS verification error for untrusted virtual host certificate")
+	}
+}
+
+// TestVirtualHosts_HostHeaderIsolation verifies expectation sets are selected
+// by Host header over plain HTTP and do not leak between hosts.
+func TestVirtualHosts_HostHeaderIsolation(t *testing.T) {
+	ms := NewMockServerWithConfig(&Config{UnmatchedStatusCode: 404, LogUnmatched: false})
+	defer ms.Close()
+
+	exp := NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/token").
+		AndRespondWithString("token", 200)
+	ms.AddVirtualHost("auth.partner.com", nil).AddExpectation(exp)
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("GET").
+		WithPath("/default").
+		AndRespondWithString("default", 200))
+
+	client := virtualHostClient(t, ms)
+	cases := []struct {
+		url    string
+		status int
+	}{
+		{"http://auth.partner.com/token", 200},
+		{"http://auth.partner.com/default", 404},
+		{ms.URL() + "/token", 404},
+		{ms.URL() + "/default", 200},
+	}
+	for _, tc := range cases {
+		resp, err := client.Get(tc.url)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.url, err)
+		}
+		safeClose(t, resp.Body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.url, tc.status, resp.StatusCode)
+		}
+	}
+
+	if err := exp.AssertCalled(1); err != nil {
+		t.Error(err)
+	}
+	exp.Times(2)
+	if err := ms.VerifyExpectations(); err == nil {
+		t.Error("expected VerifyExpectations to report the virtual host expectation")
+	}
+}
+
+// TestVirtualHosts_Management covers lookup, re-registration and removal.
+func TestVirtualHosts_Management(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+
+	vh := ms.AddVirtualHost("API.Partner.com:443", nil)
+	if vh.Hostname() != "api.partner.com" {
+		t.Errorf("expected normalized hostname, got %q", vh.Hostname())
+	}
+	if ms.AddVirtualHost("api.partner.com", nil) != vh {
+		t.Error("expected re-registration to return the existing virtual host")
+	}
+	if ms.VirtualHost("api.partner.com") != vh {
+		t.Error("expected lookup to return the registered virtual host")
+	}
+
+	exp := NewExpectation().WithRequestMethod("GET").WithPath("/x")
+	vh.AddExpectation(exp)
+	if !vh.RemoveExpectation(exp) || vh.RemoveExpectation(exp) {
+		t.Error("expected expectation to be removed exactly once")
+	}
+	if !ms.RemoveVirtualHost("api.partner.com") || ms.VirtualHost("api.partner.com") != nil {
+		t.Error("expected virtual host to be removed")
+	}
+	if ms.RemoveVirtualHost("api.partner.com") {
+		t.Error("expected second removal to report false")
+	}
+}
@@ -0,0 +1,411 @@
+package moxy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// xmlNode is a parsed XML element. Names carry the resolved namespace URI,
+// attributes exclude namespace declarations and text is whitespace-trimmed.
+type xmlNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	texts    []string // direct text around the children: texts[i] precedes children[i], the last follows them
+	children []*xmlNode
+}
+
+// WithRequestXMLBody sets an XML body matcher for this Expectation.
+// Documents are compared canonically: insignificant whitespace, attribute
+// order, namespace prefixes, comments and processing instructions are ignored.
+// It panics if the expected XML is invalid.
+// Example: .WithRequestXMLBody(`<user id="1"><name>Alice</name></user>`)
+func (e *Expectation) WithRequestXMLBody(expected string) *Expectation {
+	expectedRoot, err := parseXML([]byte(expected))
+	if err != nil {
+		panic(fmt.Errorf("invalid expected XML: %w", err))
+	}
+	e.Request.BodyMatcher = func(actual []byte) bool {
+		actualRoot, err := parseXML(actual)
+		if err != nil {
+			return false
+		}
+		return reflect.DeepEqual(expectedRoot, actualRoot)
+	}
+	e.Request.Body = nil
+	return e
+}
+
+// WithXPath adds a matcher requiring that the XPath expression selects at
+// least one node whose string value equals value. Multiple XPath matchers must
+// all match. Supported subset:
+//   - absolute steps (/a/b) and descendant steps (//b)
+//   - element names with or without a namespace prefix (prefixes are ignored) and *
+//   - predicates [n] (1-based position among siblings) and [@attr='value']
+//   - a final @attr step to select an attribute, or text() to select direct text;
+//     with // they apply to every descendant, e.g. //@id
+//
+// Example: .WithXPath("//GetUserRequest/UserId", "42")
+func (e *Expectation) WithXPath(expr, value string) *Expectation {
+	steps, err := compileXPath(expr)
+	if err != nil {
+		panic(fmt.Sprintf("invalid XPath %q: %v", expr, err))
+	}
+	e.Request.XPaths = append(e.Request.XPaths, XPathExpectation{
+		Expr:  expr,
+		Value: value,
+		steps: steps,
+	})
+	return e
+}
+
+// WithSOAPAction adds a SOAP action matcher. It matches the SOAPAction header
+// of SOAP 1.1 requests (surrounding quotes are ignored) and the action
+// parameter of the application/soap+xml Content-Type used by SOAP 1.2.
+// Example: .WithSOAPAction("http://example.com/GetUser")
+func (e *Expectation) WithSOAPAction(action string) *Expectation {
+	e.Request.SOAPAction = action
+	return e
+}
+
+// soapAction extracts the SOAP action of a request, for SOAP 1.1 or 1.2.
+func soapAction(r *http.Request) string {
+	if values, ok := r.Header["Soapaction"]; ok && len(values) > 0 {
+		return strings.Trim(values[0], `"`)
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/soap+xml" {
+		return params["action"]
+	}
+	return ""
+}
+
+// xpathMismatch evaluates XPath matchers against body and explains the first
+// mismatch. It returns an empty string if all matchers match.
+func xpathMismatch(paths []XPathExpectation, body []byte) string {
+	root, err := parseXML(body)
+	if err != nil {
+		return fmt.Sprintf("xpath: body is not valid XML: %v", err)
+	}
+	for _, p := range paths {
+		values := evaluateXPath(p.steps, root)
+		if !containsString(values, p.Value) {
+			if len(values) == 0 {
+				return fmt.Sprintf("xpath %q: selected nothing, expected %q", p.Expr, p.Value)
+			}
+			return fmt.Sprintf("xpath %q: expected %q, got %q", p.Expr, p.Value, values)
+		}
+	}
+	return ""
+}
+
+// parseXML parses a document into its root element.
+func parseXML(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name, texts: []string{""}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					continue
+				}
+				node.attrs = append(node.attrs, attr)
+			}
+			sort.Slice(node.attrs, func(i, j int) bool {
+				if node.attrs[i].Name.Space != node.attrs[j].Name.Space {
+					return node.attrs[i].Name.Space < node.attrs[j].Name.Space
+				}
+				return node.attrs[i].Name.Local < node.attrs[j].Name.Local
+			})
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+				parent.texts = append(parent.texts, "")
+			} else if root == nil {
+				root = node
+			} else {
+				return nil, fmt.Errorf("multiple root elements")
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			node := stack[len(stack)-1]
+			node.trimTexts()
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				node := stack[len(stack)-1]
+				node.texts[len(node.texts)-1] += string(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return root, nil
+}
+
+// trimTexts drops insignificant whitespace from the direct text: leading and
+// trailing whitespace of the element content and whitespace-only segments
+// between children, which are indentation.
+func (n *xmlNode) trimTexts() {
+	last := len(n.texts) - 1
+	n.texts[0] = strings.TrimLeftFunc(n.texts[0], unicode.IsSpace)
+	n.texts[last] = strings.TrimRightFunc(n.texts[last], unicode.IsSpace)
+	for i, text := range n.texts {
+		if strings.TrimSpace(text) == "" {
+			n.texts[i] = ""
+		}
+	}
+}
+
+// text returns the direct text of the node, without the text of its children.
+func (n *xmlNode) text() string {
+	return strings.Join(n.texts, "")
+}
+
+// stringValue returns the text of the node and its descendants in document
+// order.
+func (n *xmlNode) stringValue() string {
+	if len(n.children) == 0 {
+		return n.texts[0]
+	}
+	var sb strings.Builder
+	for i, child := range n.children {
+		sb.WriteString(n.texts[i])
+		sb.WriteString(child.stringValue())
+	}
+	sb.WriteString(n.texts[len(n.children)])
+	return sb.String()
+}
+
+// descendants returns all descendant elements of n in document order.
+func (n *xmlNode) descendants() []*xmlNode {
+	var result []*xmlNode
+	for _, child := range n.children {
+		result = append(result, child)
+		result = append(result, child.descendants()...)
+	}
+	return result
+}
+
+// descendantsOrSelf returns the nodes and all their descendants, without
+// duplicates for nodes nested in one another.
+func descendantsOrSelf(nodes []*xmlNode) []*xmlNode {
+	seen := make(map[*xmlNode]bool)
+	var result []*xmlNode
+	for _, node := range nodes {
+		for _, n := range append([]*xmlNode{node}, node.descendants()...) {
+			if !seen[n] {
+				seen[n] = true
+				result = append(result, n)
+			}
+		}
+	}
+	return result
+}
+
+// xpathStep is a single location step of a compiled XPath expression.
+type xpathStep struct {
+	descendant bool   // "//" axis instead of "/"
+	name       string // local name or "*"
+	attribute  string // final @attr step
+	text       bool   // final text() step
+	position   int    // [n] predicate, 0 if absent
+	attrName   string // [@attr='value'] predicate
+	attrValue  string
+	hasAttr    bool
+}
+
+// compileXPath parses the supported XPath subset.
+func compileXPath(expr string) ([]xpathStep, error) {
+	if !strings.HasPrefix(expr, "/") {
+		return nil, fmt.Errorf("only absolute paths are supported")
+	}
+	var steps []xpathStep
+	rest := expr
+	for rest != "" {
+		var step xpathStep
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			step.descendant = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "/"):
+			rest = rest[1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+		end := len(rest)
+		depth := 0
+		for i, c := range rest {
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+			} else if c == '/' && depth == 0 {
+				end = i
+				break
+			}
+		}
+		token := rest[:end]
+		rest = rest[end:]
+		if err := step.parse(token); err != nil {
+			return nil, err
+		}
+		if (step.attribute != "" || step.text) && rest != "" {
+			return nil, fmt.Errorf("%q must be the last step", token)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parse fills the step from a single location step token such as ns:item[2].
+func (s *xpathStep) parse(token string) error {
+	if token == "" {
+		return fmt.Errorf("empty step")
+	}
+	if token == "text()" {
+		s.text = true
+		return nil
+	}
+	if strings.HasPrefix(token, "@") {
+		s.attribute = localName(token[1:])
+		return nil
+	}
+	name := token
+	if i := strings.Index(token, "["); i != -1 {
+		if !strings.HasSuffix(token, "]") {
+			return fmt.Errorf("unterminated predicate in %q", token)
+		}
+		name = token[:i]
+		predicate := strings.TrimSpace(token[i+1 : len(token)-1])
+		if strings.HasPrefix(predicate, "@") {
+			parts := strings.SplitN(predicate[1:], "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("unsupported predicate %q", predicate)
+			}
+			value := strings.TrimSpace(parts[1])
+			if len(value) < 2 || (value[0] != '\'' && value[0] != '"') || value[len(value)-1] != value[0] {
+				return fmt.Errorf("predicate value must be quoted in %q", predicate)
+			}
+			s.hasAttr = true
+			s.attrName = localName(strings.TrimSpace(parts[0]))
+			s.attrValue = value[1 : len(value)-1]
+		} else {
+			position, err := strconv.Atoi(predicate)
+			if err != nil || position < 1 {
+				return fmt.Errorf("unsupported predicate %q", predicate)
+			}
+			s.position = position
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("missing element name in %q", token)
+	}
+	s.name = localName(name)
+	return nil
+}
+
+// localName strips an optional namespace prefix.
+func localName(name string) string {
+	if i := strings.LastIndex(name, ":"); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// evaluateXPath returns the string values of all nodes selected by steps.
+func evaluateXPath(steps []xpathStep, root *xmlNode) []string {
+	document := &xmlNode{children: []*xmlNode{root}}
+	context := []*xmlNode{document}
+	for _, step := range steps {
+		if step.attribute != "" || step.text {
+			// A final "//@attr" or "//text()" looks at every node below the
+			// context, not only at the context nodes themselves.
+			if step.descendant {
+				context = descendantsOrSelf(context)
+			}
+			var values []string
+			for _, node := range context {
+				if step.text {
+					if text := node.text(); text != "" || !step.descendant {
+						values = append(values, text)
+					}
+					continue
+				}
+				for _, attr := range node.attrs {
+					if attr.Name.Local == step.attribute {
+						values = append(values, attr.Value)
+					}
+				}
+			}
+			return values
+		}
+		// Name tests and positions apply to the children of each node on the
+		// axis; "//" first expands every context node to itself and its
+		// descendants, so //item[2] is the second item of each parent.
+		parents := context
+		if step.descendant {
+			parents = descendantsOrSelf(context)
+		}
+		var next []*xmlNode
+		for _, node := range parents {
+			next = append(next, step.filter(node.children)...)
+		}
+		context = next
+	}
+	values := make([]string, 0, len(context))
+	for _, node := range context {
+		values = append(values, strings.TrimSpace(node.stringValue()))
+	}
+	return values
+}
+
+// filter applies the step's name test and predicates to candidates.
+func (s xpathStep) filter(candidates []*xmlNode) []*xmlNode {
+	var matched []*xmlNode
+	for _, node := range candidates {
+		if s.name != "*" && node.name.Local != s.name {
+			continue
+		}
+		if s.hasAttr && !node.hasAttr(s.attrName, s.attrValue) {
+			continue
+		}
+		matched = append(matched, node)
+	}
+	if s.position > 0 {
+		if s.position > len(matched) {
+			return nil
+		}
+		return matched[s.position-1 : s.position]
+	}
+	return matched
+}
+
+// hasAttr reports whether the node has an attribute with the given local name and value.
+func (n *xmlNode) hasAttr(name, value string) bool {
+	for _, attr := range n.attrs {
+		if attr.Name.Local == name && attr.Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package moxy
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const getUserEnvelope = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:u="http://example.com/users">
+	<soap:Header/>
+	<soap:Body>
+		<u:GetUserRequest version="2">
+			<u:UserId>42</u:UserId>
+			<u:Fields>
+				<u:Field name="email">include</u:Field>
+				<u:Field name="phone">exclude</u:Field>
+			</u:Fields>
+		</u:GetUserRequest>
+	</soap:Body>
+</soap:Envelope>`
+
+func TestWithRequestXMLBody_CanonicalComparison(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/users").
+		WithRequestXMLBody(`<user id="1" role="admin"><name>Alice</name><tags><tag>a</tag><tag>b</tag></tags></user>`)
+
+	r, _ := http.NewRequest("POST", "/users", nil)
+	cases := []struct {
+		name  string
+		body  string
+		match bool
+	}{
+		{"identical", `<user id="1" role="admin"><name>Alice</name><tags><tag>a</tag><tag>b</tag></tags></user>`, true},
+		{"whitespace and attribute order", `<?xml version="1.0"?>
+<user role="admin"  id="1">
+	<name> Alice </name>
+	<!-- comment -->
+	<tags>
+		<tag>a</tag>
+		<tag>b</tag>
+	</tags>
+</user>`, true},
+		{"different text", `<user id="1" role="admin"><name>Bob</name><tags><tag>a</tag><tag>b</tag></tags></user>`, false},
+		{"different attribute", `<user id="2" role="admin"><name>Alice</name><tags><tag>a</tag><tag>b</tag></tags></user>`, false},
+		{"child order matters", `<user id="1" role="admin"><name>Alice</name><tags><tag>b</tag><tag>a</tag></tags></user>`, false},
+		{"invalid xml", `<user id="1">`, false},
+	}
+	for _, tc := range cases {
+		if got := e.matches(r, []byte(tc.body)); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v", tc.name, tc.match, got)
+		}
+	}
+}
+
+func TestWithRequestXMLBody_NamespacePrefixesIgnored(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/").
+		WithRequestXMLBody(`<a:item xmlns:a="urn:items"><a:id>1</a:id></a:item>`)
+
+	r, _ := http.NewRequest("POST", "/", nil)
+	if !e.matches(r, []byte(`<item xmlns="urn:items"><id>1</id></item>`)) {
+		t.Error("expected documents with the same namespace URI to match")
+	}
+	if e.matches(r, []byte(`<a:item xmlns:a="urn:other"><a:id>1</a:id></a:item>`)) {
+		t.Error("expected documents with different namespace URIs not to match")
+	}
+}
+
+func TestWithXPath(t *testing.T) {
+	cases := []struct {
+		name  string
+		expr  string
+		value string
+		match bool
+	}{
+		{"absolute prefixed", "/soap:Envelope/soap:Body/u:GetUserRequest/u:UserId", "42", true},
+		{"absolute unprefixed", "/Envelope/Body/GetUserRequest/UserId", "42", true},
+		{"descendant", "//UserId", "42", true},
+		{"descendant wrong value", "//UserId", "7", false},
+		{"attribute", "//GetUserRequest/@version", "2", true},
+		{"position", "//Fields/Field[2]", "exclude", true},
+		{"attribute predicate", "//Field[@name='email']", "include", true},
+		{"attribute predicate double quotes", `//Field[@name="phone"]`, "exclude", true},
+		{"any of several nodes", "//Field", "exclude", true},
+		{"wildcard", "/Envelope/Body/*/UserId", "42", true},
+		{"text", "//UserId/text()", "42", true},
+		{"missing", "//Address", "x", false},
+		{"position out of range", "//Fields/Field[3]", "include", false},
+	}
+	r, _ := http.NewRequest("POST", "/soap", nil)
+	for _, tc := range cases {
+		e := NewExpectation().
+			WithRequestMethod("POST").
+			WithPath("/soap").
+			WithXPath(tc.expr, tc.value)
+		if got := e.matches(r, []byte(getUserEnvelope)); got != tc.match {
+			t.Errorf("%s: expected match=%v, got %v (%s)", tc.name, tc.match, got, e.mismatch(r, []byte(getUserEnvelope)))
+		}
+	}
+}
+
+func TestEvaluateXPath_DocumentSemantics(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		expr string
+		want []string
+	}{
+		{"position per parent", `<r><g><item>a</item><item>b</item></g><g><item>c</item><item>d</item></g></r>`, "//item[2]", []string{"b", "d"}},
+		{"position per parent below a step", `<r><g><item>a</item><item>b</item></g><g><item>c</item></g></r>`, "/r//item[1]", []string{"a", "c"}},
+		{"nested descendants once", `<r><g><g><item>a</item></g></g></r>`, "//g//item", []string{"a"}},
+		{"mixed content in document order", `<a>x<b>y</b>z</a>`, "/a", []string{"xyz"}},
+		{"mixed content keeps inner spaces", `<p>Hello <b>big</b> world</p>`, "/p", []string{"Hello big world"}},
+		{"direct text only", `<a>x<b>y</b>z</a>`, "/a/text()", []string{"xz"}},
+		{"descendant attributes", `<a id="1"><b id="2"><c id="3"/></b><d/></a>`, "//@id", []string{"1", "2", "3"}},
+		{"descendant attributes below a step", `<r id="0"><a id="1"><b id="2"/></a></r>`, "/r/a//@id", []string{"1", "2"}},
+		{"descendant text", `<x>p<y>q</y><z/></x>`, "//x//text()", []string{"p", "q"}},
+	}
+	for _, tc := range cases {
+		root, err := parseXML([]byte(tc.doc))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		steps, err := compileXPath(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if got := evaluateXPath(steps, root); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+
+	e := NewExpectation().WithRequestXMLBody(`<a>x<b>y</b>z</a>`)
+	if e.Request.BodyMatcher([]byte(`<a>xz<b>y</b></a>`)) {
+		t.Error("expected mixed content in a different order not to match")
+	}
+	if !e.Request.BodyMatcher([]byte("<a>x<b> y </b>z\n</a>")) {
+		t.Error("expected surrounding whitespace to be ignored")
+	}
+}
+
+func TestWithXPath_MismatchReasons(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/soap").
+		WithXPath("//UserId", "42").
+		WithXPath("//Field[@name='email']", "exclude")
+
+	r, _ := http.NewRequest("POST", "/soap", nil)
+	reason := e.mismatch(r, []byte(getUserEnvelope))
+	if reason != `xpath "//Field[@name='email']": expected "exclude", got ["include"]` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+	if reason := e.mismatch(r, []byte(`{"json":true}`)); !strings.Contains(reason, "not valid XML") {
+		t.Errorf("unexpected mismatch reason for invalid XML: %q", reason)
+	}
+}
+
+func TestWithXPath_InvalidExpressionPanics(t *testing.T) {
+	for _, expr := range []string{"UserId", "//UserId/", "//Field[", "//Field[0]", "//Field[@name=email]", "//@id/name"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for invalid XPath %q", expr)
+				}
+			}()
+			NewExpectation().WithXPath(expr, "")
+		}()
+	}
+}
+
+func TestWithSOAPAction(t *testing.T) {
+	e := NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/soap").
+		WithSOAPAction("http://example.com/GetUser")
+
+	soap11, _ := http.NewRequest("POST", "/soap", nil)
+	soap11.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	soap11.Header.Set("SOAPAction", `"http://example.com/GetUser"`)
+	if !e.matches(soap11, nil) {
+		t.Errorf("expected SOAP 1.1 request to match: %s", e.mismatch(soap11, nil))
+	}
+
+	soap12, _ := http.NewRequest("POST", "/soap", nil)
+	soap12.Header.Set("Content-Type", `application/soap+xml; charset=utf-8; action="http://example.com/GetUser"`)
+	if !e.matches(soap12, nil) {
+		t.Errorf("expected SOAP 1.2 request to match: %s", e.mismatch(soap12, nil))
+	}
+
+	other, _ := http.NewRequest("POST", "/soap", nil)
+	other.Header.Set("SOAPAction", "http://example.com/DeleteUser")
+	if reason := e.mismatch(other, nil); reason != `soap action: expected "http://example.com/GetUser", got "http://example.com/DeleteUser"` {
+		t.Errorf("unexpected mismatch reason: %q", reason)
+	}
+}
+
+func TestMockServer_SOAPRequest(t *testing.T) {
+	ms := NewMockServer()
+	defer ms.Close()
+	ms.AddExpectation(NewExpectation().
+		WithRequestMethod("POST").
+		WithPath("/soap").
+		WithSOAPAction("http://example.com/GetUser").
+		WithXPath("//GetUserRequest/UserId", "42").
+		AndRespondWithString(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body/></soap:Envelope>`, 200))
+
+	req, _ := http.NewRequest("POST", ms.URL()+"/soap", strings.NewReader(getUserEnvelope))
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", `"http://example.com/GetUser"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer safeClose(t, resp.Body)
+	if resp.StatusCode != 200 {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+}